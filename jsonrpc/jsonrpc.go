@@ -20,19 +20,29 @@ func (c *scodec) WriteResponse(r *rpc.Response, x interface{}) error {
 }
 
 // Handle ...
-var Handle = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case "POST":
-		ch := make(chan error, 1)
-		conn := &struct {
-			io.ReadCloser
-			io.Writer
-		}{r.Body, w}
-		rpc.ServeCodec(&scodec{ch, org.NewServerCodec(conn)})
-		<-ch
-	default:
-		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		io.WriteString(w, "405 POST only\n")
-	}
-})
+var Handle = http.HandlerFunc(Handler(nil).ServeHTTP)
+
+// Handler serves JSON-RPC over POST, wrap (if not nil) may decorate the
+// codec of each request, e.g. to inspect the http.Request.
+func Handler(wrap func(r *http.Request, c rpc.ServerCodec) rpc.ServerCodec) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "POST":
+			ch := make(chan error, 1)
+			conn := &struct {
+				io.ReadCloser
+				io.Writer
+			}{r.Body, w}
+			var codec rpc.ServerCodec = &scodec{ch, org.NewServerCodec(conn)}
+			if wrap != nil {
+				codec = wrap(r, codec)
+			}
+			rpc.ServeCodec(codec)
+			<-ch
+		default:
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			io.WriteString(w, "405 POST only\n")
+		}
+	})
+}
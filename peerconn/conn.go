@@ -1,6 +1,7 @@
 package peerconn
 
 import (
+	"crypto/ed25519"
 	"sync"
 
 	"github.com/nobonobo/webrtc"
@@ -14,6 +15,24 @@ type Conn struct {
 	candidates    []*webrtc.IceCandidate
 	datachans     map[string]*webrtc.DataChannel
 	ondatachannel func(dc *webrtc.DataChannel)
+	identity      ed25519.PublicKey
+
+	onicestate   func(state string)
+	onpairchange func(pair *CandidatePair)
+	pair         *CandidatePair
+	watchOnce    sync.Once
+	recheck      chan struct{}
+	closeOnce    sync.Once
+	closed       chan struct{}
+	onstats      func(stats *ConnStats)
+	stats        *ConnStats
+	statsOnce    sync.Once
+}
+
+// iceStateNotifier is implemented by backends telling the ICE connection
+// state, without it the selected pair is only polled.
+type iceStateNotifier interface {
+	OnIceConnectionStateChange(fn func(state string))
 }
 
 // NewConn ...
@@ -23,6 +42,8 @@ func NewConn(peer string, pc *webrtc.PeerConnection) *Conn {
 		peer:           peer,
 		candidates:     []*webrtc.IceCandidate{},
 		datachans:      map[string]*webrtc.DataChannel{},
+		recheck:        make(chan struct{}, 1),
+		closed:         make(chan struct{}),
 	}
 	pc.OnDataChannel(func(dc *webrtc.DataChannel) {
 		p.SetDataChannel(dc)
@@ -30,9 +51,32 @@ func NewConn(peer string, pc *webrtc.PeerConnection) *Conn {
 			p.ondatachannel(dc)
 		}
 	})
+	if n, ok := interface{}(pc).(iceStateNotifier); ok {
+		n.OnIceConnectionStateChange(func(state string) {
+			// an ICE restart or a failover may select another pair.
+			select {
+			case p.recheck <- struct{}{}:
+			default:
+			}
+			p.mu.RLock()
+			fn := p.onicestate
+			p.mu.RUnlock()
+			if fn != nil {
+				fn(state)
+			}
+		})
+	}
 	return p
 }
 
+// OnIceConnectionStateChange calls fn on ICE connection state changes,
+// if the webrtc backend tells them.
+func (p *Conn) OnIceConnectionStateChange(fn func(state string)) {
+	p.mu.Lock()
+	p.onicestate = fn
+	p.mu.Unlock()
+}
+
 // OnDataChannel ...
 func (p *Conn) OnDataChannel(fn func(dc *webrtc.DataChannel)) {
 	p.ondatachannel = fn
@@ -47,6 +91,7 @@ func (p *Conn) SetDataChannel(dc *webrtc.DataChannel) {
 
 // Close ...
 func (p *Conn) Close() error {
+	p.closeOnce.Do(func() { close(p.closed) })
 	var err error
 	p.mu.Lock()
 	for _, dc := range p.datachans {
@@ -66,6 +111,20 @@ func (p *Conn) Peer() string {
 	return p.peer
 }
 
+// Identity returns the key which signed the DTLS fingerprints of the
+// peer, or nil when the node does not verify identities.
+func (p *Conn) Identity() ed25519.PublicKey {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.identity
+}
+
+func (p *Conn) setIdentity(key ed25519.PublicKey) {
+	p.mu.Lock()
+	p.identity = key
+	p.mu.Unlock()
+}
+
 // AppendIceCandidate ...
 func (p *Conn) AppendIceCandidate(ic *webrtc.IceCandidate) {
 	p.mu.Lock()
@@ -69,4 +69,5 @@ func init() {
 	signaling.Register(func() signaling.Kinder { return new(AnswerCandidate) })
 	signaling.Register(func() signaling.Kinder { return new(AnswerCompleted) })
 	signaling.Register(func() signaling.Kinder { return new(AnswerFailed) })
+	signaling.Register(func() signaling.Kinder { return new(Identity) })
 }
@@ -0,0 +1,206 @@
+package peerconn
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nobonobo/p2pfw/signaling"
+)
+
+// ErrUntrusted ...
+var ErrUntrusted = errors.New("untrusted identity")
+
+// Identity proves that the DTLS fingerprints of the SDP which follows
+// belong to the holder of Key.
+type Identity struct {
+	Key          ed25519.PublicKey
+	Fingerprints []string
+	Sig          []byte
+}
+
+// Kind ...
+func (c *Identity) Kind() string { return "identity" }
+
+// identityMessage binds the signature to both ends and to the room,
+// so a proof can not be replayed to another peer.
+func identityMessage(room, from, to string, fingerprints []string) []byte {
+	return []byte(strings.Join([]string{
+		"p2pfw-dtls", room, from, to, strings.Join(fingerprints, ","),
+	}, "\x00"))
+}
+
+// signIdentity ...
+func signIdentity(key ed25519.PrivateKey, room, from, to, sdp string) (*Identity, error) {
+	fps := fingerprints(sdp)
+	if len(fps) == 0 {
+		return nil, errors.New("no fingerprint in sdp")
+	}
+	return &Identity{
+		Key:          key.Public().(ed25519.PublicKey),
+		Fingerprints: fps,
+		Sig:          ed25519.Sign(key, identityMessage(room, from, to, fps)),
+	}, nil
+}
+
+// verify checks that id was made by from for to and covers every
+// fingerprint of sdp.
+func (c *Identity) verify(room, from, to, sdp string) error {
+	if len(c.Key) != ed25519.PublicKeySize {
+		return errors.New("invalid identity key")
+	}
+	if !ed25519.Verify(c.Key, identityMessage(room, from, to, c.Fingerprints), c.Sig) {
+		return errors.New("identity signature mismatch")
+	}
+	signed := map[string]bool{}
+	for _, fp := range c.Fingerprints {
+		signed[fp] = true
+	}
+	fps := fingerprints(sdp)
+	if len(fps) == 0 {
+		return errors.New("no fingerprint in sdp")
+	}
+	for _, fp := range fps {
+		if !signed[fp] {
+			return fmt.Errorf("fingerprint not signed: %s", fp)
+		}
+	}
+	return nil
+}
+
+// fingerprints returns the normalized a=fingerprint values of sdp.
+func fingerprints(sdp string) []string {
+	seen := map[string]bool{}
+	fps := []string{}
+	for _, line := range strings.Split(sdp, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "a=fingerprint:") {
+			continue
+		}
+		f := strings.Fields(strings.TrimPrefix(line, "a=fingerprint:"))
+		if len(f) != 2 {
+			continue
+		}
+		fp := strings.ToLower(f[0]) + " " + strings.ToUpper(f[1])
+		if !seen[fp] {
+			seen[fp] = true
+			fps = append(fps, fp)
+		}
+	}
+	sort.Strings(fps)
+	return fps
+}
+
+// TrustStore decides whether key is the identity of peer.
+type TrustStore interface {
+	Verify(peer string, key ed25519.PublicKey) error
+}
+
+// Pinned trusts only the keys listed for each peer.
+type Pinned map[string]ed25519.PublicKey
+
+// Verify ...
+func (p Pinned) Verify(peer string, key ed25519.PublicKey) error {
+	pinned, ok := p[peer]
+	if !ok || !bytes.Equal(pinned, key) {
+		return fmt.Errorf("%w: %s", ErrUntrusted, peer)
+	}
+	return nil
+}
+
+// KeyIDs trusts a key when the UserID of the peer is derived from it,
+// which the signaling server checked at Join.
+type KeyIDs struct{}
+
+// Verify ...
+func (KeyIDs) Verify(peer string, key ed25519.PublicKey) error {
+	if signaling.KeyUserID(key) != peer {
+		return fmt.Errorf("%w: %s", ErrUntrusted, peer)
+	}
+	return nil
+}
+
+// TOFU trusts the first key seen for each peer and rejects any other
+// key afterwards.
+type TOFU struct {
+	mu   sync.Mutex
+	keys map[string]ed25519.PublicKey
+	path string
+}
+
+// NewTOFU keeps the known keys in the JSON file at path, or only in
+// memory if path is empty.
+func NewTOFU(path string) (*TOFU, error) {
+	t := &TOFU{keys: map[string]ed25519.PublicKey{}, path: path}
+	if len(path) == 0 {
+		return t, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return t, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &t.keys); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Verify ...
+func (t *TOFU) Verify(peer string, key ed25519.PublicKey) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if known, ok := t.keys[peer]; ok {
+		if !bytes.Equal(known, key) {
+			return fmt.Errorf("%w: %s changed its key", ErrUntrusted, peer)
+		}
+		return nil
+	}
+	t.keys[peer] = key
+	if err := t.flush(); err != nil {
+		delete(t.keys, peer)
+		return err
+	}
+	return nil
+}
+
+// Forget drops the key of peer, so the next one is trusted again.
+func (t *TOFU) Forget(peer string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.keys, peer)
+	return t.flush()
+}
+
+func (t *TOFU) flush() error {
+	if len(t.path) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(t.keys)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(t.path), filepath.Base(t.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), t.path)
+}
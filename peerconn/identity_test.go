@@ -0,0 +1,130 @@
+package peerconn
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/nobonobo/p2pfw/signaling"
+)
+
+const (
+	fpA = "a=fingerprint:sha-256 AB:CD:EF"
+	fpB = "a=fingerprint:sha-256 12:34:56"
+)
+
+func newKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestFingerprints(t *testing.T) {
+	tests := []struct {
+		sdp  string
+		want []string
+	}{
+		{"v=0\r\n" + fpA + "\r\n", []string{"sha-256 AB:CD:EF"}},
+		{"a=fingerprint:SHA-256 ab:cd:ef\n" + fpA + "\n" + fpB, []string{"sha-256 12:34:56", "sha-256 AB:CD:EF"}},
+		{"a=fingerprint:sha-256\n", []string{}},
+		{"v=0\n", []string{}},
+	}
+	for _, tt := range tests {
+		got := fingerprints(tt.sdp)
+		if len(got) != len(tt.want) {
+			t.Errorf("%q: %v, want %v", tt.sdp, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%q: %v, want %v", tt.sdp, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestIdentityVerify(t *testing.T) {
+	key := newKey(t)
+	id, err := signIdentity(key, "room", "alice", "bob", fpA+"\n"+fpB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := *id
+	forged.Key = newKey(t).Public().(ed25519.PublicKey)
+	tests := []struct {
+		name           string
+		id             *Identity
+		room, from, to string
+		sdp            string
+		fail           bool
+	}{
+		{"valid", id, "room", "alice", "bob", fpB + "\n" + fpA, false},
+		{"subset of fingerprints", id, "room", "alice", "bob", fpA, false},
+		{"other room", id, "other", "alice", "bob", fpA, true},
+		{"replayed to another peer", id, "room", "alice", "carol", fpA, true},
+		{"other sender", id, "room", "mallory", "bob", fpA, true},
+		{"unsigned fingerprint", id, "room", "alice", "bob", fpA + "\na=fingerprint:sha-256 FF:FF", true},
+		{"no fingerprint", id, "room", "alice", "bob", "v=0", true},
+		{"other key", &forged, "room", "alice", "bob", fpA, true},
+		{"no key", &Identity{}, "room", "alice", "bob", fpA, true},
+	}
+	for _, tt := range tests {
+		if err := tt.id.verify(tt.room, tt.from, tt.to, tt.sdp); (err != nil) != tt.fail {
+			t.Errorf("%s: %v, want failure %v", tt.name, err, tt.fail)
+		}
+	}
+	if _, err := signIdentity(key, "room", "alice", "bob", "v=0"); err == nil {
+		t.Errorf("signed an sdp without fingerprint")
+	}
+}
+
+func TestTrustStores(t *testing.T) {
+	alice := newKey(t).Public().(ed25519.PublicKey)
+	other := newKey(t).Public().(ed25519.PublicKey)
+	path := filepath.Join(t.TempDir(), "known.json")
+	tofu, err := NewTOFU(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		trust TrustStore
+		peer  string
+		key   ed25519.PublicKey
+		fail  bool
+	}{
+		{"pinned", Pinned{"alice": alice}, "alice", alice, false},
+		{"pinned other key", Pinned{"alice": alice}, "alice", other, true},
+		{"not pinned", Pinned{"alice": alice}, "bob", alice, true},
+		{"key id", KeyIDs{}, signaling.KeyUserID(alice), alice, false},
+		{"key id mismatch", KeyIDs{}, signaling.KeyUserID(alice), other, true},
+		{"key id plain user", KeyIDs{}, "alice", alice, true},
+		{"tofu first", tofu, "alice", alice, false},
+		{"tofu again", tofu, "alice", alice, false},
+		{"tofu changed", tofu, "alice", other, true},
+	}
+	for _, tt := range tests {
+		err := tt.trust.Verify(tt.peer, tt.key)
+		if (err != nil) != tt.fail || (err != nil && !errors.Is(err, ErrUntrusted)) {
+			t.Errorf("%s: %v, want failure %v", tt.name, err, tt.fail)
+		}
+	}
+	reloaded, err := NewTOFU(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reloaded.Verify("alice", other); err == nil {
+		t.Errorf("reloaded tofu forgot alice")
+	}
+	if err := reloaded.Forget("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloaded.Verify("alice", other); err != nil {
+		t.Errorf("after forget: %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package peerconn
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SetStatsInterval makes connections made afterwards take a stats
+// snapshot every d, 0 disables.
+func (n *Node) SetStatsInterval(d time.Duration) {
+	n.mu.Lock()
+	n.statsInterval = d
+	n.mu.Unlock()
+}
+
+func (n *Node) startStats(conn *Conn) {
+	n.mu.Lock()
+	d := n.statsInterval
+	n.mu.Unlock()
+	if d > 0 {
+		conn.StartStats(d)
+	}
+}
+
+// Stats returns the latest snapshots of the connections by role,
+// "client" for Clients and "server" for Servers.
+func (n *Node) Stats() map[string][]*ConnStats {
+	res := map[string][]*ConnStats{"client": {}, "server": {}}
+	for role, conns := range map[string]*Connections{"client": n.Clients, "server": n.Servers} {
+		conns.Iter(func(_ string, c *Conn) {
+			if s := c.LastStats(); s != nil {
+				res[role] = append(res[role], s)
+			}
+		})
+	}
+	return res
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+type metric struct {
+	name, typ, help string
+	conn            func(*ConnStats) float64
+	channel         func(*DataChannelStats) float64
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+var metrics = []metric{
+	{name: "p2pfw_peer_bytes_sent_total", typ: "counter", help: "Bytes sent on the ICE transport.",
+		conn: func(s *ConnStats) float64 { return float64(s.BytesSent) }},
+	{name: "p2pfw_peer_bytes_received_total", typ: "counter", help: "Bytes received on the ICE transport.",
+		conn: func(s *ConnStats) float64 { return float64(s.BytesReceived) }},
+	{name: "p2pfw_peer_packets_sent_total", typ: "counter", help: "Packets sent on the ICE transport.",
+		conn: func(s *ConnStats) float64 { return float64(s.PacketsSent) }},
+	{name: "p2pfw_peer_packets_received_total", typ: "counter", help: "Packets received on the ICE transport.",
+		conn: func(s *ConnStats) float64 { return float64(s.PacketsReceived) }},
+	{name: "p2pfw_peer_packets_lost_total", typ: "counter", help: "Packets lost on the ICE or SCTP transport.",
+		conn: func(s *ConnStats) float64 { return float64(s.PacketsLost) }},
+	{name: "p2pfw_peer_rtt_seconds", typ: "gauge", help: "Round trip time of the selected candidate pair.",
+		conn: func(s *ConnStats) float64 { return s.RTT.Seconds() }},
+	{name: "p2pfw_peer_relayed", typ: "gauge", help: "1 if the selected candidate pair goes through TURN.",
+		conn: func(s *ConnStats) float64 { return boolValue(s.Pair != nil && s.Pair.Relayed()) }},
+	{name: "p2pfw_datachannel_messages_sent_total", typ: "counter", help: "Messages sent on the data channel.",
+		channel: func(s *DataChannelStats) float64 { return float64(s.MessagesSent) }},
+	{name: "p2pfw_datachannel_messages_received_total", typ: "counter", help: "Messages received on the data channel.",
+		channel: func(s *DataChannelStats) float64 { return float64(s.MessagesReceived) }},
+	{name: "p2pfw_datachannel_bytes_sent_total", typ: "counter", help: "Payload bytes sent on the data channel.",
+		channel: func(s *DataChannelStats) float64 { return float64(s.BytesSent) }},
+	{name: "p2pfw_datachannel_bytes_received_total", typ: "counter", help: "Payload bytes received on the data channel.",
+		channel: func(s *DataChannelStats) float64 { return float64(s.BytesReceived) }},
+}
+
+// WriteMetrics writes the latest snapshots in the Prometheus text
+// format, labelled by room, peer and role (and id and label for channels).
+func (n *Node) WriteMetrics(w io.Writer) error {
+	stats := n.Stats()
+	bw := bufio.NewWriter(w)
+	room := labelEscaper.Replace(n.r.RoomID)
+	for _, m := range metrics {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+		for _, role := range []string{"client", "server"} {
+			for _, s := range stats[role] {
+				labels := fmt.Sprintf(`room="%s",peer="%s",role="%s"`, room, labelEscaper.Replace(s.Peer), role)
+				if m.conn != nil {
+					fmt.Fprintf(bw, "%s{%s} %g\n", m.name, labels, m.conn(s))
+					continue
+				}
+				for i := range s.DataChannels {
+					dc := &s.DataChannels[i]
+					fmt.Fprintf(bw, "%s{%s,id=\"%d\",label=\"%s\"} %g\n",
+						m.name, labels, dc.ID, labelEscaper.Replace(dc.Label), m.channel(dc))
+				}
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// MetricsHandler serves WriteMetrics, for the /metrics of the application.
+func (n *Node) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := n.WriteMetrics(w); err != nil {
+			log.Println("metrics:", err)
+		}
+	})
+}
@@ -0,0 +1,53 @@
+package peerconn
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/nobonobo/p2pfw/signaling"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats *ConnStats
+		lines []string
+	}{
+		{"conn", &ConnStats{Peer: "bob", PacketsLost: 3, Pair: &CandidatePair{
+			Local: Candidate{Type: CandidateRelay},
+		}}, []string{
+			`p2pfw_peer_packets_lost_total{room="room",peer="bob",role="server"} 3`,
+			`p2pfw_peer_relayed{room="room",peer="bob",role="server"} 1`,
+		}},
+		{"escaped", &ConnStats{Peer: `b"o\b`}, []string{
+			`p2pfw_peer_bytes_sent_total{room="room",peer="b\"o\\b",role="server"} 0`,
+		}},
+		{"channels", &ConnStats{Peer: "bob", DataChannels: []DataChannelStats{
+			{ID: 1, Label: "chat", MessagesSent: 2},
+			{ID: 3, Label: "chat", MessagesSent: 5},
+		}}, []string{
+			`p2pfw_datachannel_messages_sent_total{room="room",peer="bob",role="server",id="1",label="chat"} 2`,
+			`p2pfw_datachannel_messages_sent_total{room="room",peer="bob",role="server",id="3",label="chat"} 5`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := &Node{
+				r:       signaling.Request{RoomID: "room", UserID: "me"},
+				Clients: NewConnections(),
+				Servers: NewConnections(),
+			}
+			n.Servers.m[tt.stats.Peer] = &Conn{stats: tt.stats}
+			var buf bytes.Buffer
+			if err := n.WriteMetrics(&buf); err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range tt.lines {
+				if !strings.Contains(buf.String(), line+"\n") {
+					t.Errorf("no %s in\n%s", line, buf.String())
+				}
+			}
+		})
+	}
+}
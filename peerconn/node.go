@@ -1,7 +1,12 @@
 package peerconn
 
 import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/nobonobo/p2pfw/signaling"
 	"github.com/nobonobo/p2pfw/signaling/client"
@@ -17,15 +22,30 @@ type Node struct {
 	err       error
 
 	config  *webrtc.Configuration
+	servers []signaling.IceServer // ours when config is nil
+	e2e     *signaling.E2E
+	key     ed25519.PrivateKey
+	trust   TrustStore
+	proofs  map[string]*Identity
 	Clients *Connections // 接続元
 	Servers *Connections // 接続先
 
+	mu            sync.Mutex
+	policy        Policy
+	peerPolicies  map[string]Policy
+	statsInterval time.Duration
+
 	OnJoin           func(member string)
 	OnLeave          func(member string)
+	OnKnock          func(member string)
+	OnKicked         func(by string, k *signaling.Kicked) // we were kicked, the node stops
 	OnPeerConnection func(string, *Conn) error
 }
 
-// NewNode ...
+// NewNode creates a node, with a nil config the ICE servers (TURN
+// included) are asked from the signaling server at Start, and again
+// whenever their credentials are about to expire. Which candidates are
+// exchanged with peers is set by SetPolicy and SetPeerPolicy.
 func NewNode(dial *client.Config, config *webrtc.Configuration) (*Node, error) {
 	c, err := client.New(dial)
 	if err != nil {
@@ -35,10 +55,14 @@ func NewNode(dial *client.Config, config *webrtc.Configuration) (*Node, error) {
 		r:                dial.Request,
 		rpcClient:        c,
 		config:           config,
+		proofs:           map[string]*Identity{},
+		peerPolicies:     map[string]Policy{},
 		Clients:          NewConnections(),
 		Servers:          NewConnections(),
 		OnJoin:           func(string) {},
 		OnLeave:          func(string) {},
+		OnKnock:          func(string) {},
+		OnKicked:         func(string, *signaling.Kicked) {},
 		OnPeerConnection: func(string, *Conn) error { return nil },
 	}
 	n.done = make(chan error)
@@ -48,82 +72,129 @@ func NewNode(dial *client.Config, config *webrtc.Configuration) (*Node, error) {
 
 func (n *Node) run() {
 	defer close(n.done)
+	var rc client.Reconnector
 	for {
-		if err := n.rpcClient.Call("Signaling.Join", n.r, client.None); err != nil {
+		err := n.rpcClient.Call("Signaling.Join", n.r, client.None)
+		if err == nil {
+			rc.Connected()
+			events := new([]*signaling.Event)
+			call := n.rpcClient.Go("Signaling.Pull", n.r, &events, nil)
+			select {
+			case <-n.closing:
+				return
+			case <-call.Done:
+			}
+			if err = call.Error; err == nil {
+				if err := n.dispatch(*events); err != nil {
+					n.done <- err
+					return
+				}
+				if delay, ok := rc.GoingAway(*events); ok && !n.reconnect(delay) {
+					return
+				}
+				continue
+			}
+		}
+		_, throttled := signaling.AsRateLimitError(err)
+		delay, err := rc.Failed(err)
+		if err != nil {
 			n.done <- err
 			return
 		}
-		events := new([]*signaling.Event)
-		call := n.rpcClient.Go("Signaling.Pull", n.r, &events, nil)
-		select {
-		case <-n.closing:
-			return
-		case <-call.Done:
-			if call.Error != nil {
-				n.done <- call.Error
+		if throttled {
+			// the connection is fine, only too busy.
+			if !n.wait(delay) {
 				return
 			}
-			n.dispatch(*events)
+			continue
+		}
+		if !n.reconnect(delay) {
+			return
 		}
 	}
 }
 
-func (n *Node) dispatch(events []*signaling.Event) {
+// reconnect drops the connection and waits delay, it returns false when
+// the node stops meanwhile.
+func (n *Node) reconnect(delay time.Duration) bool {
+	log.Printf("reconnect to signaling server after %s", delay)
+	n.rpcClient.Close()
+	return n.wait(delay)
+}
+
+// wait returns after delay, or false when the node stops meanwhile.
+func (n *Node) wait(delay time.Duration) bool {
+	select {
+	case <-n.closing:
+		return false
+	case <-time.After(delay):
+		return true
+	}
+}
+
+// dispatch handles events, it fails when we are kicked out of the room.
+func (n *Node) dispatch(events []*signaling.Event) error {
 	for _, ev := range events {
+		if n.e2e != nil {
+			opened, err := n.e2e.Open(ev)
+			if err != nil {
+				log.Printf("%s: %s", ev.From, err)
+				continue
+			}
+			ev = opened
+		}
 		msg := ev.Get()
 		log.Printf("recv from %s: %#v", ev.From, msg)
 		switch v := msg.(type) {
 		case *signaling.Join:
 			n.OnJoin(v.Member)
 		case *signaling.Leave:
+			n.forget(v.Member)
 			n.OnLeave(v.Member)
-		case *Connect:
-			pc, err := webrtc.NewPeerConnection(n.config)
-			if err != nil {
-				log.Printf("%s: %s", ev.From, err)
-				break
+		case *signaling.Kicked:
+			if v.Member == n.r.UserID {
+				log.Printf("kicked by %s: %s", ev.From, v.Reason)
+				n.OnKicked(ev.From, v)
+				return fmt.Errorf("kicked by %s: %s", ev.From, v.Reason)
 			}
-			conn := NewConn(ev.From, pc)
-			conn.OnIceCandidate(func(ic *webrtc.IceCandidate) {
-				if err := n.Send(ev.From, (*OfferCandidate)(ic)); err != nil {
-					log.Printf("%s: %s", ev.From, err)
-				}
-			})
-			conn.OnIceCandidateError(func() {
-				log.Printf("%s: ice candidate failed", ev.From)
-				if err := n.Send(ev.From, &OfferFailed{}); err != nil {
-					log.Printf("%s: %s", ev.From, err)
-				}
-			})
-			conn.OnIceGatheringStateChange(func(s string) {
-				log.Printf("%s: ice gathering state change %q", ev.From, s)
-				if s == "Complete" {
-					if err := n.Send(ev.From, &OfferCompleted{}); err != nil {
-						log.Printf("%s: %s", ev.From, err)
-					}
-				}
-			})
-			if err := n.OnPeerConnection(ev.From, conn); err != nil {
-				log.Printf("%s: %s", ev.From, err)
+			n.forget(v.Member)
+			n.OnLeave(v.Member)
+		case *signaling.Knock:
+			n.OnKnock(v.Member)
+		case *signaling.PublicKey:
+			if n.e2e == nil {
 				break
 			}
-			sdp, err := conn.CreateOffer()
+			added, err := n.e2e.Accept(ev.From, v)
 			if err != nil {
 				log.Printf("%s: %s", ev.From, err)
 				break
 			}
-			if err := conn.SetLocalDescription(sdp); err != nil {
-				log.Printf("%s: %s", ev.From, err)
-				break
+			// answer a broadcast so that the newcomer gets our key too.
+			if added && ev.To == "" {
+				if err := n.Send(ev.From, n.e2e.PublicKey()); err != nil {
+					log.Printf("%s: %s", ev.From, err)
+				}
 			}
-			if err := n.Send(ev.From, (*Offer)(sdp)); err != nil {
+		case *signaling.GoingAway:
+			log.Printf("signaling server going away, reconnect after %s", v.Reconnect)
+		case *Identity:
+			n.proofs[ev.From] = v
+		case *Connect:
+			if err := n.offer(ev.From); err != nil {
 				log.Printf("%s: %s", ev.From, err)
-				break
 			}
-			n.Clients.Set(ev.From, conn)
 		case *Offer:
 			if conn := n.Servers.Get(ev.From); conn != nil {
 				sdp := (*webrtc.SessionDescription)(v)
+				if err := n.verify(ev.From, conn, sdp); err != nil {
+					log.Printf("%s: %s", ev.From, err)
+					n.Servers.Del(ev.From)
+					if err := n.Send(ev.From, &AnswerFailed{}); err != nil {
+						log.Printf("%s: %s", ev.From, err)
+					}
+					break
+				}
 				if err := conn.SetRemoteDescription(sdp); err != nil {
 					log.Printf("%s: %s", ev.From, err)
 					break
@@ -137,6 +208,10 @@ func (n *Node) dispatch(events []*signaling.Event) {
 					log.Printf("%s: %s", ev.From, err)
 					break
 				}
+				if err := n.prove(ev.From, sdp); err != nil {
+					log.Printf("%s: %s", ev.From, err)
+					break
+				}
 				if err := n.Send(ev.From, (*Answer)(sdp)); err != nil {
 					log.Printf("%s: %s", ev.From, err)
 					break
@@ -145,6 +220,10 @@ func (n *Node) dispatch(events []*signaling.Event) {
 		case *OfferCandidate:
 			if conn := n.Servers.Get(ev.From); conn != nil {
 				ic := (*webrtc.IceCandidate)(v)
+				if policy := n.policyFor(ev.From); !policy.allow(ic) {
+					log.Printf("%s: candidate dropped by policy: %s", ev.From, ic.Candidate)
+					break
+				}
 				conn.AppendIceCandidate(ic)
 			}
 		case *OfferCompleted:
@@ -158,6 +237,14 @@ func (n *Node) dispatch(events []*signaling.Event) {
 		case *Answer:
 			if conn := n.Clients.Get(ev.From); conn != nil {
 				sdp := (*webrtc.SessionDescription)(v)
+				if err := n.verify(ev.From, conn, sdp); err != nil {
+					log.Printf("%s: %s", ev.From, err)
+					n.Clients.Del(ev.From)
+					if err := n.Send(ev.From, &OfferFailed{}); err != nil {
+						log.Printf("%s: %s", ev.From, err)
+					}
+					break
+				}
 				if err := conn.SetRemoteDescription(sdp); err != nil {
 					log.Printf("%s: %s", ev.From, err)
 					break
@@ -166,6 +253,10 @@ func (n *Node) dispatch(events []*signaling.Event) {
 		case *AnswerCandidate:
 			if conn := n.Clients.Get(ev.From); conn != nil {
 				ic := (*webrtc.IceCandidate)(v)
+				if policy := n.policyFor(ev.From); !policy.allow(ic) {
+					log.Printf("%s: candidate dropped by policy: %s", ev.From, ic.Candidate)
+					break
+				}
 				conn.AppendIceCandidate(ic)
 			}
 		case *AnswerCompleted:
@@ -180,6 +271,66 @@ func (n *Node) dispatch(events []*signaling.Event) {
 			log.Printf("%s: unsupported event %#v", ev.From, msg)
 		}
 	}
+	return nil
+}
+
+// offer answers a Connect of peer, the connection is closed unless the
+// offer is sent.
+func (n *Node) offer(peer string) error {
+	policy := n.policyFor(peer)
+	pc, err := n.newPeerConnection(policy)
+	if err != nil {
+		return err
+	}
+	conn := NewConn(peer, pc)
+	sent := false
+	defer func() {
+		if !sent {
+			conn.Close()
+		}
+	}()
+	n.startStats(conn)
+	conn.OnIceCandidate(func(ic *webrtc.IceCandidate) {
+		if !policy.allow(ic) {
+			return
+		}
+		if err := n.Send(peer, (*OfferCandidate)(ic)); err != nil {
+			log.Printf("%s: %s", peer, err)
+		}
+	})
+	conn.OnIceCandidateError(func() {
+		log.Printf("%s: ice candidate failed", peer)
+		if err := n.Send(peer, &OfferFailed{}); err != nil {
+			log.Printf("%s: %s", peer, err)
+		}
+	})
+	conn.OnIceGatheringStateChange(func(s string) {
+		log.Printf("%s: ice gathering state change %q", peer, s)
+		if s == "Complete" {
+			if err := n.Send(peer, &OfferCompleted{}); err != nil {
+				log.Printf("%s: %s", peer, err)
+			}
+		}
+	})
+	if err := n.OnPeerConnection(peer, conn); err != nil {
+		return err
+	}
+	sdp, err := conn.CreateOffer()
+	if err != nil {
+		return err
+	}
+	if err := conn.SetLocalDescription(sdp); err != nil {
+		return err
+	}
+	if err := n.prove(peer, sdp); err != nil {
+		return err
+	}
+	if err := n.Send(peer, (*Offer)(sdp)); err != nil {
+		return err
+	}
+	sent = true
+	n.Clients.Set(peer, conn)
+	return nil
 }
 
 // Room ...
@@ -202,12 +353,93 @@ func (n *Node) Start(owner bool) error {
 			return err
 		}
 	}
+	if _, err := n.configuration(); err != nil {
+		return err
+	}
+	if n.e2e != nil {
+		if err := n.Send("", n.e2e.PublicKey()); err != nil {
+			return err
+		}
+	}
 	n.closing = make(chan struct{})
 	n.done = make(chan error, 1)
 	go n.run()
 	return nil
 }
 
+// EnableE2E seals signaling to peers which announced their key, so
+// the signaling server sees no SDP nor candidates. Sending to a peer
+// whose key is unknown yet fails, unsealed events from peers are dropped.
+// The keys are authenticated by the room secret only, which the server
+// knows: a server tampering with them can still read the signaling.
+// Call before Start.
+func (n *Node) EnableE2E() error {
+	e, err := signaling.NewE2E(n.r.RoomID, n.r.UserID, n.r.Preshared)
+	if err != nil {
+		return err
+	}
+	n.e2e = e
+	return nil
+}
+
+// forget unpins the E2E key of a member which left.
+func (n *Node) forget(member string) {
+	if n.e2e != nil {
+		n.e2e.Forget(member)
+	}
+}
+
+// EnableIdentity signs our DTLS fingerprints with key and accepts only
+// peers whose signed fingerprints match their SDP and whose key is
+// trusted by trust (a TOFU in memory if nil). Call before Start.
+func (n *Node) EnableIdentity(key ed25519.PrivateKey, trust TrustStore) error {
+	if len(key) != ed25519.PrivateKeySize {
+		return errors.New("invalid identity key")
+	}
+	if trust == nil {
+		tofu, err := NewTOFU("")
+		if err != nil {
+			return err
+		}
+		trust = tofu
+	}
+	n.key = key
+	n.trust = trust
+	return nil
+}
+
+// prove sends the signature of our fingerprints in sdp ahead of it.
+func (n *Node) prove(peer string, sdp *webrtc.SessionDescription) error {
+	if n.key == nil {
+		return nil
+	}
+	id, err := signIdentity(n.key, n.r.RoomID, n.r.UserID, peer, sdp.Sdp)
+	if err != nil {
+		return err
+	}
+	return n.Send(peer, id)
+}
+
+// verify checks the proof which peer sent ahead of sdp.
+func (n *Node) verify(peer string, conn *Conn, sdp *webrtc.SessionDescription) error {
+	proof := n.proofs[peer]
+	delete(n.proofs, peer)
+	if n.trust == nil {
+		return nil
+	}
+	if proof == nil {
+		return errors.New("no identity proof")
+	}
+	if err := proof.verify(n.r.RoomID, peer, n.r.UserID, sdp.Sdp); err != nil {
+		return err
+	}
+	if err := n.trust.Verify(peer, proof.Key); err != nil {
+		return err
+	}
+	conn.setIdentity(proof.Key)
+	return nil
+}
+
 // Stop ...
 func (n *Node) Stop() error {
 	select {
@@ -241,8 +473,16 @@ func (n *Node) Close() error {
 // Send ...
 func (n *Node) Send(dest string, v signaling.Kinder) error {
 	log.Printf("send to %s: %#v", dest, v)
+	ev := signaling.New(n.r.UserID, dest, v)
+	if n.e2e != nil {
+		sealed, err := n.e2e.Seal(ev)
+		if err != nil {
+			return err
+		}
+		ev = sealed
+	}
 	return n.rpcClient.Call("Signaling.Send",
-		signaling.Message{Request: n.r, Event: signaling.New(n.r.UserID, dest, v)},
+		signaling.Message{Request: n.r, Event: ev},
 		client.None,
 	)
 }
@@ -256,14 +496,77 @@ func (n *Node) Members() (*signaling.Members, error) {
 	return m, nil
 }
 
+// IceServers returns the ICE servers given to us as a member.
+func (n *Node) IceServers() ([]signaling.IceServer, error) {
+	servers := []signaling.IceServer{}
+	if err := n.rpcClient.Call("Signaling.IceServers", n.r, &servers); err != nil {
+		return nil, err
+	}
+	return servers, nil
+}
+
+// IceServersRefresh is how long before their credentials expire the ICE
+// servers of a Node are asked again.
+var IceServersRefresh = time.Minute
+
+// configuration returns the configuration given to NewNode, else one of
+// our ICE servers, asked again when their credentials are about to
+// expire.
+func (n *Node) configuration() (*webrtc.Configuration, error) {
+	if n.config != nil {
+		return n.config, nil
+	}
+	n.mu.Lock()
+	servers := n.servers
+	n.mu.Unlock()
+	if servers == nil || expiring(servers, time.Now().Add(IceServersRefresh)) {
+		fresh, err := n.IceServers()
+		if err != nil {
+			return nil, err
+		}
+		n.mu.Lock()
+		n.servers = fresh
+		n.mu.Unlock()
+		servers = fresh
+	}
+	return NewConfiguration(servers), nil
+}
+
+// expiring reports whether the credentials of a server expire before t.
+func expiring(servers []signaling.IceServer, t time.Time) bool {
+	for _, s := range servers {
+		if !s.Expires.IsZero() && s.Expires.Before(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// newPeerConnection ...
+func (n *Node) newPeerConnection(policy Policy) (*webrtc.PeerConnection, error) {
+	config, err := n.configuration()
+	if err != nil {
+		return nil, err
+	}
+	if config, err = policy.configure(config); err != nil {
+		return nil, err
+	}
+	return webrtc.NewPeerConnection(config)
+}
+
 // Connect ...
 func (n *Node) Connect(peer string) (*Conn, error) {
-	pc, err := webrtc.NewPeerConnection(n.config)
+	policy := n.policyFor(peer)
+	pc, err := n.newPeerConnection(policy)
 	if err != nil {
 		return nil, err
 	}
 	conn := NewConn(peer, pc)
+	n.startStats(conn)
 	conn.OnIceCandidate(func(ic *webrtc.IceCandidate) {
+		if !policy.allow(ic) {
+			return
+		}
 		if err := n.Send(conn.Peer(), (*AnswerCandidate)(ic)); err != nil {
 			log.Printf("%s: %s", conn.Peer(), err)
 		}
@@ -283,6 +586,7 @@ func (n *Node) Connect(peer string) (*Conn, error) {
 		}
 	})
 	if err := n.Send(peer, &Connect{}); err != nil {
+		conn.Close()
 		return nil, err
 	}
 	n.Servers.Set(peer, conn)
@@ -0,0 +1,142 @@
+package peerconn
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nobonobo/p2pfw/signaling"
+	"github.com/nobonobo/webrtc"
+)
+
+func TestDispatchKicked(t *testing.T) {
+	tests := []struct {
+		name   string
+		kicked *signaling.Kicked
+		stop   bool
+	}{
+		{"self", &signaling.Kicked{Member: "me", Reason: "bye"}, true},
+		{"self banned", &signaling.Kicked{Member: "me", Banned: true}, true},
+		{"other", &signaling.Kicked{Member: "bob"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var kicked *signaling.Kicked
+			left := ""
+			n := &Node{
+				r:        signaling.Request{RoomID: "room", UserID: "me"},
+				OnLeave:  func(member string) { left = member },
+				OnKicked: func(by string, k *signaling.Kicked) { kicked = k },
+			}
+			err := n.dispatch([]*signaling.Event{signaling.New("owner", "", tt.kicked)})
+			if (err != nil) != tt.stop {
+				t.Fatalf("dispatch: %v, want stop %v", err, tt.stop)
+			}
+			if tt.stop && (kicked == nil || kicked.Banned != tt.kicked.Banned) {
+				t.Errorf("OnKicked got %+v", kicked)
+			}
+			if !tt.stop && (kicked != nil || left != tt.kicked.Member) {
+				t.Errorf("OnKicked %+v, OnLeave %q", kicked, left)
+			}
+		})
+	}
+}
+
+func TestDispatchE2E(t *testing.T) {
+	me, err := signaling.NewE2E("room", "me", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := signaling.NewE2E("room", "bob", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bob.Accept("me", me.PublicKey()); err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := bob.Seal(signaling.New("bob", "me", &Identity{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		events []*signaling.Event
+		proof  bool
+	}{
+		{"unsealed", []*signaling.Event{signaling.New("bob", "me", &Identity{})}, false},
+		{"unknown key", []*signaling.Event{sealed}, false},
+		{"sealed", []*signaling.Event{signaling.New("bob", "", bob.PublicKey()), sealed}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := &Node{
+				r:      signaling.Request{RoomID: "room", UserID: "me"},
+				e2e:    me,
+				proofs: map[string]*Identity{},
+			}
+			// answering the key needs a server, so it is known already.
+			if tt.proof {
+				if _, err := me.Accept("bob", bob.PublicKey()); err != nil {
+					t.Fatal(err)
+				}
+				defer me.Forget("bob")
+			}
+			if err := n.dispatch(tt.events); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := n.proofs["bob"]; ok != tt.proof {
+				t.Errorf("proof %v, want %v", ok, tt.proof)
+			}
+		})
+	}
+}
+
+func TestExpiring(t *testing.T) {
+	now := time.Now()
+	stun := signaling.IceServer{URLs: []string{"stun:stun.example.com"}}
+	turn := signaling.IceServer{URLs: []string{"turn:turn.example.com"}, Expires: now.Add(time.Hour)}
+	tests := []struct {
+		servers []signaling.IceServer
+		t       time.Time
+		want    bool
+	}{
+		{nil, now, false},
+		{[]signaling.IceServer{stun}, now.Add(24 * time.Hour), false},
+		{[]signaling.IceServer{stun, turn}, now, false},
+		{[]signaling.IceServer{stun, turn}, now.Add(2 * time.Hour), true},
+	}
+	for i, tt := range tests {
+		if got := expiring(tt.servers, tt.t); got != tt.want {
+			t.Errorf("%d: %v, want %v", i, got, tt.want)
+		}
+	}
+}
+
+func TestDispatchConnectFailed(t *testing.T) {
+	var conn *Conn
+	n := &Node{
+		r:            signaling.Request{RoomID: "room", UserID: "me"},
+		config:       &webrtc.Configuration{},
+		peerPolicies: map[string]Policy{},
+		Clients:      NewConnections(),
+		Servers:      NewConnections(),
+		OnPeerConnection: func(peer string, c *Conn) error {
+			conn = c
+			return errors.New("refused")
+		},
+	}
+	if err := n.dispatch([]*signaling.Event{signaling.New("bob", "me", &Connect{})}); err != nil {
+		t.Fatal(err)
+	}
+	if conn == nil {
+		t.Fatal("no connection")
+	}
+	select {
+	case <-conn.closed:
+	default:
+		t.Errorf("connection left open")
+	}
+	if n.Clients.Get("bob") != nil {
+		t.Errorf("connection kept")
+	}
+}
@@ -0,0 +1,148 @@
+package peerconn
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// PairInterval is how often a Conn with an OnSelectedPairChange handler
+// looks for a new selected candidate pair.
+var PairInterval = 2 * time.Second
+
+// Candidate ...
+type Candidate struct {
+	Type          string // CandidateHost, CandidateSrflx, CandidatePrflx or CandidateRelay
+	Protocol      string // udp or tcp
+	Address       string
+	Port          int
+	RelayProtocol string // to the TURN server, relay candidates only
+}
+
+// String ...
+func (c Candidate) String() string {
+	return fmt.Sprintf("%s %s", c.Type, net.JoinHostPort(c.Address, strconv.Itoa(c.Port)))
+}
+
+// CandidatePair is the path ICE selected for a Conn.
+type CandidatePair struct {
+	Local  Candidate
+	Remote Candidate
+	RTT    time.Duration // current round trip time, 0 if unknown
+}
+
+// Relayed reports whether the path goes through a TURN server.
+func (c *CandidatePair) Relayed() bool {
+	return c.Local.Type == CandidateRelay || c.Remote.Type == CandidateRelay
+}
+
+// String ...
+func (c *CandidatePair) String() string {
+	return fmt.Sprintf("%s %s <-> %s rtt %s", c.Local.Protocol, c.Local, c.Remote, c.RTT)
+}
+
+// same compares the candidates of c and o, not their RTT.
+func (c *CandidatePair) same(o *CandidatePair) bool {
+	if c == nil || o == nil {
+		return c == o
+	}
+	return c.Local == o.Local && c.Remote == o.Remote
+}
+
+func candidate(s map[string]interface{}) Candidate {
+	addr := str(s, "address")
+	if len(addr) == 0 {
+		addr = str(s, "ip")
+	}
+	return Candidate{
+		Type:          str(s, "candidateType"),
+		Protocol:      str(s, "protocol"),
+		Address:       addr,
+		Port:          int(num(s, "port")),
+		RelayProtocol: str(s, "relayProtocol"),
+	}
+}
+
+// selectedPair finds the selected pair through the transport, else the
+// one flagged selected or nominated and succeeded.
+func (r statsReport) selectedPair() (*CandidatePair, error) {
+	var pair map[string]interface{}
+	for _, t := range r.ofType("transport") {
+		if id := str(t, "selectedCandidatePairId"); len(id) > 0 {
+			pair = r[id]
+			break
+		}
+	}
+	if pair == nil {
+		for _, p := range r.ofType("candidate-pair") {
+			if boolean(p, "selected") || boolean(p, "nominated") && str(p, "state") == "succeeded" {
+				pair = p
+				break
+			}
+		}
+	}
+	if pair == nil {
+		return nil, errors.New("no selected candidate pair")
+	}
+	local, remote := r[str(pair, "localCandidateId")], r[str(pair, "remoteCandidateId")]
+	if local == nil || remote == nil {
+		return nil, errors.New("candidate of the selected pair not found")
+	}
+	return &CandidatePair{
+		Local:  candidate(local),
+		Remote: candidate(remote),
+		RTT:    time.Duration(num(pair, "currentRoundTripTime") * float64(time.Second)),
+	}, nil
+}
+
+// SelectedPair returns the candidate pair in use, ErrNoStats if the
+// webrtc backend can not tell.
+func (p *Conn) SelectedPair() (*CandidatePair, error) {
+	report, err := p.report()
+	if err != nil {
+		return nil, err
+	}
+	return report.selectedPair()
+}
+
+// OnSelectedPairChange calls fn with the new pair whenever ICE selects
+// another one, checked on ICE connection state changes and every
+// PairInterval.
+func (p *Conn) OnSelectedPairChange(fn func(pair *CandidatePair)) {
+	p.mu.Lock()
+	p.onpairchange = fn
+	p.mu.Unlock()
+	p.watchOnce.Do(func() { go p.watch() })
+}
+
+func (p *Conn) watch() {
+	ticker := time.NewTicker(PairInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.closed:
+			return
+		case <-p.recheck:
+		case <-ticker.C:
+		}
+		p.checkPair()
+	}
+}
+
+// checkPair ...
+func (p *Conn) checkPair() {
+	pair, err := p.SelectedPair()
+	if err != nil {
+		return
+	}
+	p.mu.Lock()
+	changed := !pair.same(p.pair)
+	p.pair = pair
+	fn := p.onpairchange
+	p.mu.Unlock()
+	if changed && fn != nil {
+		fn(pair)
+	}
+}
@@ -0,0 +1,50 @@
+package peerconn
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSelectedPair(t *testing.T) {
+	local := map[string]interface{}{"type": "local-candidate", "candidateType": "relay",
+		"protocol": "udp", "address": "203.0.113.1", "port": 50000, "relayProtocol": "tcp"}
+	remote := map[string]interface{}{"type": "remote-candidate", "candidateType": "srflx",
+		"protocol": "udp", "ip": "198.51.100.2", "port": float64(40000)}
+	want := &CandidatePair{
+		Local:  Candidate{CandidateRelay, "udp", "203.0.113.1", 50000, "tcp"},
+		Remote: Candidate{CandidateSrflx, "udp", "198.51.100.2", 40000, ""},
+		RTT:    25 * time.Millisecond,
+	}
+
+	r := statsReport{"L1": local, "R1": remote}
+	if pair, err := r.selectedPair(); err == nil {
+		t.Errorf("no pair: got %s", pair)
+	}
+	r["P1"] = map[string]interface{}{"type": "candidate-pair", "state": "in-progress", "nominated": true,
+		"localCandidateId": "L1", "remoteCandidateId": "R1", "currentRoundTripTime": 0.025}
+	if pair, err := r.selectedPair(); err == nil {
+		t.Errorf("nominated in progress: got %s", pair)
+	}
+	r["P1"]["state"] = "succeeded"
+	if pair, err := r.selectedPair(); err != nil || !pair.same(want) || pair.RTT != want.RTT || !pair.Relayed() {
+		t.Errorf("nominated: %s, %v", pair, err)
+	}
+
+	// the transport wins over the flags of the pairs.
+	r["P1"]["nominated"] = false
+	r["P2"] = map[string]interface{}{"type": "candidate-pair", "selected": true,
+		"localCandidateId": "R1", "remoteCandidateId": "L1"}
+	r["T1"] = map[string]interface{}{"type": "transport", "selectedCandidatePairId": "P1"}
+	if pair, err := r.selectedPair(); err != nil || !pair.same(want) {
+		t.Errorf("transport: %s, %v", pair, err)
+	}
+	delete(r, "T1")
+	if pair, err := r.selectedPair(); err != nil || pair.Local.Type != CandidateSrflx {
+		t.Errorf("selected: %s, %v", pair, err)
+	}
+
+	delete(r, "L1")
+	if pair, err := r.selectedPair(); err == nil {
+		t.Errorf("no candidate: got %s", pair)
+	}
+}
@@ -0,0 +1,154 @@
+package peerconn
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nobonobo/webrtc"
+)
+
+// ICE transport policies.
+const (
+	TransportAll   = "all"
+	TransportRelay = "relay" // relay candidates only, both ways
+)
+
+// Candidate types, CandidateMDNS is a host candidate with an obfuscated
+// ".local" address.
+const (
+	CandidateHost  = "host"
+	CandidateMDNS  = "mdns"
+	CandidateSrflx = "srflx"
+	CandidatePrflx = "prflx"
+	CandidateRelay = "relay"
+)
+
+// Policy controls which ICE candidates are gathered, sent to a peer and
+// accepted from it. With TransportRelay only relay candidates are
+// gathered, so our addresses reach neither the peer nor its TURN server.
+type Policy struct {
+	Transport string   // TransportAll if empty
+	Drop      []string // candidate types never sent to nor accepted from the peer
+}
+
+// check ...
+func (p *Policy) check() error {
+	switch p.Transport {
+	case "", TransportAll, TransportRelay:
+	default:
+		return fmt.Errorf("invalid ice transport policy: %q", p.Transport)
+	}
+	for _, typ := range p.Drop {
+		switch typ {
+		case CandidateHost, CandidateMDNS, CandidateSrflx, CandidatePrflx, CandidateRelay:
+		default:
+			return fmt.Errorf("invalid candidate type: %q", typ)
+		}
+	}
+	return nil
+}
+
+// configure returns a copy of config with the ICE transport policy of
+// p. The webrtc backend takes it as the iceTransportPolicy of the JSON
+// form of config, a relay policy it drops is an error rather than a
+// leak of our addresses.
+func (p *Policy) configure(config *webrtc.Configuration) (*webrtc.Configuration, error) {
+	if len(p.Transport) == 0 {
+		return config, nil
+	}
+	dict, err := configDict(config)
+	if err != nil {
+		return nil, err
+	}
+	for k := range dict {
+		if strings.EqualFold(k, "iceTransportPolicy") {
+			delete(dict, k)
+		}
+	}
+	dict["iceTransportPolicy"] = p.Transport
+	c, err := fromDict(dict)
+	if err != nil {
+		return nil, err
+	}
+	if p.Transport == TransportRelay {
+		if dict, err = configDict(c); err != nil {
+			return nil, err
+		}
+		if lookup(dict, "iceTransportPolicy") != TransportRelay {
+			return nil, errors.New("webrtc configuration has no ice transport policy")
+		}
+	}
+	return c, nil
+}
+
+// allow reports whether ic may be sent to or accepted from the peer.
+// With the relay transport only relay candidates are.
+func (p *Policy) allow(ic *webrtc.IceCandidate) bool {
+	typ := candidateType(ic.Candidate)
+	if p.Transport == TransportRelay && typ != CandidateRelay {
+		return false
+	}
+	for _, drop := range p.Drop {
+		if drop == typ || drop == CandidateHost && typ == CandidateMDNS {
+			return false
+		}
+	}
+	return true
+}
+
+// candidateType parses the type of an "a=candidate" attribute value.
+func candidateType(candidate string) string {
+	// candidate:foundation component transport priority address port typ type ...
+	fields := strings.Fields(strings.TrimPrefix(candidate, "a="))
+	for i := 0; i+1 < len(fields); i++ {
+		if fields[i] != "typ" {
+			continue
+		}
+		typ := fields[i+1]
+		if typ == CandidateHost && i >= 2 && strings.HasSuffix(fields[i-2], ".local") {
+			return CandidateMDNS
+		}
+		return typ
+	}
+	return ""
+}
+
+// SetPolicy sets the policy for peers without their own, see
+// SetPeerPolicy. It applies to connections made afterwards.
+func (n *Node) SetPolicy(p Policy) error {
+	if err := p.check(); err != nil {
+		return err
+	}
+	n.mu.Lock()
+	n.policy = p
+	n.mu.Unlock()
+	return nil
+}
+
+// SetPeerPolicy overrides the policy for peer, nil removes the override.
+func (n *Node) SetPeerPolicy(peer string, p *Policy) error {
+	if p != nil {
+		if err := p.check(); err != nil {
+			return err
+		}
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if p == nil {
+		delete(n.peerPolicies, peer)
+		return nil
+	}
+	n.peerPolicies[peer] = *p
+	return nil
+}
+
+// policyFor ...
+func (n *Node) policyFor(peer string) Policy {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if p, ok := n.peerPolicies[peer]; ok {
+		return p
+	}
+	return n.policy
+}
@@ -0,0 +1,120 @@
+package peerconn
+
+import (
+	"testing"
+
+	"github.com/nobonobo/p2pfw/signaling"
+	"github.com/nobonobo/webrtc"
+)
+
+const (
+	host  = "candidate:1 1 udp 2122260223 192.168.1.2 54321 typ host generation 0"
+	mdns  = "candidate:2 1 udp 2122260223 6b1c7d1e-09e5-4c41-a2f6-5c3e1f0d2b7a.local 54321 typ host"
+	srflx = "candidate:3 1 udp 1686052607 203.0.113.5 54321 typ srflx raddr 192.168.1.2 rport 54321"
+	prflx = "a=candidate:4 1 udp 1845501695 203.0.113.6 60000 typ prflx raddr 0.0.0.0 rport 0"
+	relay = "candidate:5 1 udp 41885439 198.51.100.9 49200 typ relay raddr 203.0.113.5 rport 54321"
+)
+
+func TestCandidateType(t *testing.T) {
+	tests := []struct {
+		candidate string
+		want      string
+	}{
+		{host, CandidateHost},
+		{mdns, CandidateMDNS},
+		{srflx, CandidateSrflx},
+		{prflx, CandidatePrflx},
+		{relay, CandidateRelay},
+		{"candidate:1 1 tcp 1518280447 192.168.1.2 9 typ host tcptype active", CandidateHost},
+		{"", ""},
+		{"candidate:1 1 udp 1 192.168.1.2 9 typ", ""},
+	}
+	for _, tt := range tests {
+		if got := candidateType(tt.candidate); got != tt.want {
+			t.Errorf("%q: %q, want %q", tt.candidate, got, tt.want)
+		}
+	}
+}
+
+func TestPolicyAllow(t *testing.T) {
+	all := []string{host, mdns, srflx, prflx, relay}
+	tests := []struct {
+		name   string
+		policy Policy
+		want   []bool // for all
+	}{
+		{"default", Policy{}, []bool{true, true, true, true, true}},
+		{"all", Policy{Transport: TransportAll}, []bool{true, true, true, true, true}},
+		{"relay only", Policy{Transport: TransportRelay}, []bool{false, false, false, false, true}},
+		{"drop host", Policy{Drop: []string{CandidateHost}}, []bool{false, false, true, true, true}},
+		{"drop mdns", Policy{Drop: []string{CandidateMDNS}}, []bool{true, false, true, true, true}},
+		{"drop srflx and relay", Policy{Drop: []string{CandidateSrflx, CandidateRelay}}, []bool{true, true, false, true, false}},
+		{"relay only dropping relay", Policy{Transport: TransportRelay, Drop: []string{CandidateRelay}}, []bool{false, false, false, false, false}},
+	}
+	for _, tt := range tests {
+		if err := tt.policy.check(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		for i, c := range all {
+			if got := tt.policy.allow(&webrtc.IceCandidate{Candidate: c}); got != tt.want[i] {
+				t.Errorf("%s: %s allowed %v, want %v", tt.name, candidateType(c), got, tt.want[i])
+			}
+		}
+	}
+	for _, bad := range []Policy{{Transport: "none"}, {Drop: []string{"turn"}}} {
+		if err := bad.check(); err == nil {
+			t.Errorf("%+v accepted", bad)
+		}
+	}
+}
+
+func TestPolicyConfigure(t *testing.T) {
+	config := NewConfiguration([]signaling.IceServer{{URLs: []string{"turn:turn.example.com"}, Username: "u"}})
+	if c, err := (&Policy{}).configure(config); c != config || err != nil {
+		t.Errorf("default: %+v, %v", c, err)
+	}
+	c, err := (&Policy{Transport: TransportAll}).configure(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dict, _ := configDict(c)
+	if servers, _ := lookup(dict, "iceServers").([]interface{}); len(servers) != 1 {
+		t.Errorf("all: %v", dict)
+	}
+	// a backend without the policy must not gather host candidates.
+	c, err = (&Policy{Transport: TransportRelay}).configure(config)
+	if err == nil {
+		dict, _ := configDict(c)
+		if lookup(dict, "iceTransportPolicy") != TransportRelay {
+			t.Errorf("relay dropped: %v", dict)
+		}
+	}
+}
+
+func TestDispatchRemoteCandidates(t *testing.T) {
+	pc, err := webrtc.NewPeerConnection(&webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := NewConn("bob", pc)
+	defer conn.Close()
+	n := &Node{
+		r:            signaling.Request{RoomID: "room", UserID: "me"},
+		Servers:      NewConnections(),
+		Clients:      NewConnections(),
+		peerPolicies: map[string]Policy{"bob": {Transport: TransportRelay}},
+	}
+	n.Servers.Set("bob", conn)
+	events := []*signaling.Event{}
+	for _, c := range []string{host, srflx, relay} {
+		events = append(events, signaling.New("bob", "me", &OfferCandidate{Candidate: c}))
+	}
+	if err := n.dispatch(events); err != nil {
+		t.Fatal(err)
+	}
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	if len(conn.candidates) != 1 || conn.candidates[0].Candidate != relay {
+		t.Errorf("accepted %d candidates", len(conn.candidates))
+	}
+}
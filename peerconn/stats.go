@@ -0,0 +1,202 @@
+package peerconn
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"time"
+)
+
+// ErrNoStats is returned when the webrtc backend does not report stats.
+var ErrNoStats = errors.New("webrtc stats not supported")
+
+// statsReporter is implemented by backends exposing getStats, each
+// entry is a W3C RTCStats dictionary ("id", "type", "timestamp", ...).
+type statsReporter interface {
+	GetStats() ([]map[string]interface{}, error)
+}
+
+// statsReport is a getStats result by stats id.
+type statsReport map[string]map[string]interface{}
+
+// report ...
+func (p *Conn) report() (statsReport, error) {
+	r, ok := interface{}(p.PeerConnection).(statsReporter)
+	if !ok || p.PeerConnection == nil {
+		return nil, ErrNoStats
+	}
+	stats, err := r.GetStats()
+	if err != nil {
+		return nil, err
+	}
+	report := statsReport{}
+	for _, s := range stats {
+		report[str(s, "id")] = s
+	}
+	return report, nil
+}
+
+// ofType returns the stats of typ.
+func (r statsReport) ofType(typ string) []map[string]interface{} {
+	res := []map[string]interface{}{}
+	for _, s := range r {
+		if str(s, "type") == typ {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+func str(s map[string]interface{}, key string) string {
+	v, _ := s[key].(string)
+	return v
+}
+
+func num(s map[string]interface{}, key string) float64 {
+	switch v := s[key].(type) {
+	case float64:
+		return v
+	case float32:
+		return float64(v)
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case uint32:
+		return float64(v)
+	}
+	return 0
+}
+
+func boolean(s map[string]interface{}, key string) bool {
+	v, _ := s[key].(bool)
+	return v
+}
+
+// DataChannelStats ...
+type DataChannelStats struct {
+	ID               uint16 // the stream id, labels need not be unique
+	Label            string
+	State            string
+	MessagesSent     uint64
+	MessagesReceived uint64
+	BytesSent        uint64
+	BytesReceived    uint64
+}
+
+// ConnStats is a snapshot of the counters of a Conn.
+type ConnStats struct {
+	Peer            string
+	Time            time.Time
+	BytesSent       uint64
+	BytesReceived   uint64
+	PacketsSent     uint64
+	PacketsReceived uint64
+	PacketsLost     uint64 // of the ICE transports, else of the SCTP transport
+	RTT             time.Duration
+	Pair            *CandidatePair // nil until ICE selects one
+	DataChannels    []DataChannelStats
+}
+
+// stats ...
+func (r statsReport) stats(peer string) *ConnStats {
+	s := &ConnStats{Peer: peer, Time: time.Now(), DataChannels: []DataChannelStats{}}
+	lost := false
+	for _, t := range r.ofType("transport") {
+		s.BytesSent += uint64(num(t, "bytesSent"))
+		s.BytesReceived += uint64(num(t, "bytesReceived"))
+		s.PacketsSent += uint64(num(t, "packetsSent"))
+		s.PacketsReceived += uint64(num(t, "packetsReceived"))
+		if _, ok := t["packetsLost"]; ok {
+			s.PacketsLost += uint64(num(t, "packetsLost"))
+			lost = true
+		}
+	}
+	if !lost {
+		for _, sctp := range r.ofType("sctp-transport") {
+			s.PacketsLost += uint64(num(sctp, "packetsLost"))
+		}
+	}
+	if pair, err := r.selectedPair(); err == nil {
+		s.Pair = pair
+		s.RTT = pair.RTT
+	}
+	for _, dc := range r.ofType("data-channel") {
+		s.DataChannels = append(s.DataChannels, DataChannelStats{
+			ID:               uint16(num(dc, "dataChannelIdentifier")),
+			Label:            str(dc, "label"),
+			State:            str(dc, "state"),
+			MessagesSent:     uint64(num(dc, "messagesSent")),
+			MessagesReceived: uint64(num(dc, "messagesReceived")),
+			BytesSent:        uint64(num(dc, "bytesSent")),
+			BytesReceived:    uint64(num(dc, "bytesReceived")),
+		})
+	}
+	sort.Slice(s.DataChannels, func(i, j int) bool {
+		a, b := s.DataChannels[i], s.DataChannels[j]
+		if a.Label != b.Label {
+			return a.Label < b.Label
+		}
+		return a.ID < b.ID
+	})
+	return s
+}
+
+// Stats asks the webrtc backend for the counters of p now.
+func (p *Conn) Stats() (*ConnStats, error) {
+	report, err := p.report()
+	if err != nil {
+		return nil, err
+	}
+	return report.stats(p.peer), nil
+}
+
+// LastStats returns the latest snapshot taken by StartStats, or nil.
+func (p *Conn) LastStats() *ConnStats {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.stats
+}
+
+// OnStats calls fn with each snapshot taken by StartStats.
+func (p *Conn) OnStats(fn func(stats *ConnStats)) {
+	p.mu.Lock()
+	p.onstats = fn
+	p.mu.Unlock()
+}
+
+// StartStats takes a snapshot every interval until Close, or until the
+// backend turns out to have no stats.
+func (p *Conn) StartStats(interval time.Duration) {
+	p.statsOnce.Do(func() { go p.collect(interval) })
+}
+
+func (p *Conn) collect(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.closed:
+			return
+		case <-ticker.C:
+		}
+		stats, err := p.Stats()
+		if err == ErrNoStats {
+			log.Printf("%s: %s", p.peer, err)
+			return
+		}
+		if err != nil {
+			log.Printf("%s: stats: %s", p.peer, err)
+			continue
+		}
+		p.mu.Lock()
+		p.stats = stats
+		fn := p.onstats
+		p.mu.Unlock()
+		if fn != nil {
+			fn(stats)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package peerconn
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats []map[string]interface{}
+		want  ConnStats
+	}{
+		{"empty", nil, ConnStats{DataChannels: []DataChannelStats{}}},
+		{"transports", []map[string]interface{}{
+			{"id": "T1", "type": "transport", "bytesSent": 100, "bytesReceived": uint64(200),
+				"packetsSent": float64(3), "packetsReceived": int64(4)},
+			{"id": "T2", "type": "transport", "bytesSent": 10, "bytesReceived": 20},
+			{"id": "C1", "type": "codec", "bytesSent": 1000},
+		}, ConnStats{
+			BytesSent: 110, BytesReceived: 220, PacketsSent: 3, PacketsReceived: 4,
+			DataChannels: []DataChannelStats{},
+		}},
+		{"transport lost", []map[string]interface{}{
+			{"id": "T1", "type": "transport", "packetsLost": 3},
+			{"id": "S1", "type": "sctp-transport", "packetsLost": 7},
+			{"id": "I1", "type": "inbound-rtp", "packetsLost": 11},
+		}, ConnStats{PacketsLost: 3, DataChannels: []DataChannelStats{}}},
+		{"sctp lost", []map[string]interface{}{
+			{"id": "T1", "type": "transport", "bytesSent": 1},
+			{"id": "S1", "type": "sctp-transport", "packetsLost": 7},
+			{"id": "I1", "type": "inbound-rtp", "packetsLost": 11},
+		}, ConnStats{BytesSent: 1, PacketsLost: 7, DataChannels: []DataChannelStats{}}},
+		{"data channels", []map[string]interface{}{
+			{"id": "D3", "type": "data-channel", "dataChannelIdentifier": 4, "label": "video", "state": "open"},
+			{"id": "D2", "type": "data-channel", "dataChannelIdentifier": 2, "label": "video", "state": "open", "messagesSent": 2},
+			{"id": "D1", "type": "data-channel", "dataChannelIdentifier": 0, "label": "chat", "state": "closing", "bytesReceived": 5},
+		}, ConnStats{DataChannels: []DataChannelStats{
+			{ID: 0, Label: "chat", State: "closing", BytesReceived: 5},
+			{ID: 2, Label: "video", State: "open", MessagesSent: 2},
+			{ID: 4, Label: "video", State: "open"},
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := statsReport{}
+			for _, s := range tt.stats {
+				r[str(s, "id")] = s
+			}
+			got := r.stats("bob")
+			if got.Peer != "bob" || got.Time.IsZero() {
+				t.Errorf("peer %q, time %s", got.Peer, got.Time)
+			}
+			got.Peer, got.Time = "", tt.want.Time
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("got %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
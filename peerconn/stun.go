@@ -1,13 +1,52 @@
 package peerconn
 
 import (
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/nobonobo/p2pfw/signaling"
+	"github.com/nobonobo/p2pfw/signaling/client"
+	"github.com/nobonobo/webrtc"
 )
 
-// GetDefaultStunHosts ...
+// signalingHTTP returns the http(s) URL of path on the signaling server
+// at signalingURL (ws or wss), client.DefaultSignalingServer if empty.
+func signalingHTTP(signalingURL, path string) (string, error) {
+	if len(signalingURL) == 0 {
+		signalingURL = client.DefaultSignalingServer
+	}
+	u, err := url.Parse(signalingURL)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "wss", "https":
+		u.Scheme = "https"
+	case "ws", "http":
+		u.Scheme = "http"
+	default:
+		return "", fmt.Errorf("not supported scheme: %s", u.Scheme)
+	}
+	u.Path = path
+	u.RawQuery = ""
+	return u.String(), nil
+}
+
+// GetDefaultStunHosts returns the comma separated STUN servers of the
+// default signaling server.
+//
+// Deprecated: use GetConfiguration.
 func GetDefaultStunHosts() (string, error) {
-	resp, err := http.Get("https://signaling.irieda.com/stun")
+	u, err := signalingHTTP("", "/stun")
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.Get(u)
 	if err != nil {
 		return "", err
 	}
@@ -18,3 +57,95 @@ func GetDefaultStunHosts() (string, error) {
 	}
 	return string(b), nil
 }
+
+// GetIceServers fetches the public ICE servers of the signaling server at
+// signalingURL. TURN servers are only given to members, see Node.
+func GetIceServers(signalingURL string) ([]signaling.IceServer, error) {
+	u, err := signalingHTTP(signalingURL, "/ice-servers")
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get %s: %s", u, resp.Status)
+	}
+	servers := []signaling.IceServer{}
+	if err := json.NewDecoder(resp.Body).Decode(&servers); err != nil {
+		return nil, err
+	}
+	return servers, nil
+}
+
+// NewConfiguration builds the configuration through its JSON form, the
+// W3C RTCConfiguration dictionary.
+func NewConfiguration(servers []signaling.IceServer) *webrtc.Configuration {
+	list := []interface{}{}
+	for _, s := range servers {
+		server := map[string]interface{}{"urls": s.URLs}
+		if len(s.Username) > 0 {
+			server["username"] = s.Username
+			server["credential"] = s.Credential
+		}
+		list = append(list, server)
+	}
+	config, err := fromDict(map[string]interface{}{"iceServers": list})
+	if err != nil {
+		log.Println("ice servers:", err)
+		return &webrtc.Configuration{}
+	}
+	return config
+}
+
+// configDict returns config in its JSON form, a W3C RTCConfiguration
+// dictionary.
+func configDict(config *webrtc.Configuration) (map[string]interface{}, error) {
+	dict := map[string]interface{}{}
+	if config == nil {
+		return dict, nil
+	}
+	b, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &dict); err != nil {
+		return nil, err
+	}
+	return dict, nil
+}
+
+// fromDict is the reverse of configDict.
+func fromDict(dict map[string]interface{}) (*webrtc.Configuration, error) {
+	b, err := json.Marshal(dict)
+	if err != nil {
+		return nil, err
+	}
+	config := &webrtc.Configuration{}
+	if err := json.Unmarshal(b, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// lookup gets key of dict, matched as encoding/json does.
+func lookup(dict map[string]interface{}, key string) interface{} {
+	for k, v := range dict {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return nil
+}
+
+// GetConfiguration builds a configuration from the public ICE servers of
+// the signaling server at signalingURL.
+func GetConfiguration(signalingURL string) (*webrtc.Configuration, error) {
+	servers, err := GetIceServers(signalingURL)
+	if err != nil {
+		return nil, err
+	}
+	return NewConfiguration(servers), nil
+}
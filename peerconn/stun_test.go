@@ -0,0 +1,66 @@
+package peerconn
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nobonobo/p2pfw/signaling"
+)
+
+func TestSignalingHTTP(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+		fail bool
+	}{
+		{"wss://example.com/ws", "https://example.com/ice-servers", false},
+		{"ws://localhost:8080/ws?token=x", "http://localhost:8080/ice-servers", false},
+		{"https://example.com", "https://example.com/ice-servers", false},
+		{"ftp://example.com", "", true},
+	}
+	for _, tt := range tests {
+		got, err := signalingHTTP(tt.url, "/ice-servers")
+		if got != tt.want || (err != nil) != tt.fail {
+			t.Errorf("%s: %q, %v, want %q", tt.url, got, err, tt.want)
+		}
+	}
+}
+
+func TestGetConfiguration(t *testing.T) {
+	servers := []signaling.IceServer{
+		{URLs: []string{"stun:stun.example.com:3478"}},
+		{URLs: []string{"turn:turn.example.com:3478"}, Username: "u", Credential: "p"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ice-servers" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(servers)
+	}))
+	defer server.Close()
+	config, err := GetConfiguration("ws" + strings.TrimPrefix(server.URL, "http") + "/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dict, err := configDict(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := lookup(dict, "iceServers").([]interface{})
+	if len(got) != len(servers) {
+		t.Fatalf("got %v", dict)
+	}
+	for i, s := range got {
+		s, _ := s.(map[string]interface{})
+		want := servers[i]
+		if fmt.Sprint(lookup(s, "urls")) != fmt.Sprint(want.URLs) ||
+			want.Username != "" && (lookup(s, "username") != want.Username || lookup(s, "credential") != want.Credential) {
+			t.Errorf("server %d: %v, want %+v", i, s, want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package signaling
+
+import (
+	"sync"
+)
+
+// Envelope is the unit exchanged between server instances for a room.
+type Envelope struct {
+	Origin string     // server instance that published it
+	Event  *Event     `json:",omitempty"`
+	State  *RoomState `json:",omitempty"` // answer to Lookup
+	Lookup bool       `json:",omitempty"`
+	Closed bool       `json:",omitempty"`
+	// a member joined or left the room on the Origin instance.
+	Presence *Presence `json:",omitempty"`
+}
+
+// Presence ...
+type Presence struct {
+	Member string
+	Joined bool
+}
+
+// Broker routes room envelopes between signaling server instances.
+type Broker interface {
+	Publish(room string, env *Envelope) error
+	Subscribe(room string, fn func(*Envelope)) (unsubscribe func(), err error)
+	Close() error
+}
+
+type subscriber struct {
+	fn func(*Envelope)
+}
+
+// LocalBroker is an in-process Broker, for servers sharing one process.
+type LocalBroker struct {
+	mu   sync.RWMutex
+	subs map[string]map[*subscriber]struct{}
+}
+
+// NewLocalBroker ...
+func NewLocalBroker() *LocalBroker {
+	return &LocalBroker{subs: map[string]map[*subscriber]struct{}{}}
+}
+
+// Publish ...
+func (b *LocalBroker) Publish(room string, env *Envelope) error {
+	b.mu.RLock()
+	subs := make([]*subscriber, 0, len(b.subs[room]))
+	for sub := range b.subs[room] {
+		subs = append(subs, sub)
+	}
+	b.mu.RUnlock()
+	for _, sub := range subs {
+		sub.fn(env)
+	}
+	return nil
+}
+
+// Subscribe ...
+func (b *LocalBroker) Subscribe(room string, fn func(*Envelope)) (func(), error) {
+	sub := &subscriber{fn: fn}
+	b.mu.Lock()
+	if b.subs[room] == nil {
+		b.subs[room] = map[*subscriber]struct{}{}
+	}
+	b.subs[room][sub] = struct{}{}
+	b.mu.Unlock()
+	return func() {
+		b.mu.Lock()
+		delete(b.subs[room], sub)
+		if len(b.subs[room]) == 0 {
+			delete(b.subs, room)
+		}
+		b.mu.Unlock()
+	}, nil
+}
+
+// Close ...
+func (b *LocalBroker) Close() error {
+	b.mu.Lock()
+	b.subs = map[string]map[*subscriber]struct{}{}
+	b.mu.Unlock()
+	return nil
+}
@@ -1,12 +1,14 @@
 package client
 
 import (
+	"crypto/ed25519"
 	"fmt"
 	"log"
 	"net/rpc"
 	"net/rpc/jsonrpc"
 	"net/url"
 	"sync"
+	"time"
 
 	"github.com/goxjs/websocket"
 	"github.com/nobonobo/p2pfw/signaling"
@@ -25,6 +27,7 @@ type Config struct {
 	signaling.Request
 	URL    string
 	Origin string
+	Key    ed25519.PrivateKey // UserID defaults to signaling.KeyUserID of it, see LoadKey
 }
 
 // Client ...
@@ -34,6 +37,7 @@ type Client struct {
 	dialer func() (*rpc.Client, error)
 	config *Config
 	closed bool
+	proven *rpc.Client // connection bound to the key derived UserID
 }
 
 // New ...
@@ -44,6 +48,15 @@ func New(config *Config) (*Client, error) {
 	if len(config.URL) == 0 {
 		config.URL = DefaultSignalingServer
 	}
+	if config.Key != nil {
+		id := signaling.KeyUserID(config.Key.Public().(ed25519.PublicKey))
+		if len(config.UserID) == 0 {
+			config.UserID = id
+		}
+		if config.UserID != id {
+			return nil, fmt.Errorf("UserID does not match Key: %s", config.UserID)
+		}
+	}
 	if len(config.UserID) == 0 {
 		uuid, err := UUID()
 		if err != nil {
@@ -110,8 +123,19 @@ func (client *Client) Go(serviceMethod string, args interface{}, reply interface
 	return rpcClient.Go(serviceMethod, args, reply, done)
 }
 
-// Call ...
+// Call ... A rate limited call is repeated up to MaxThrottled times.
 func (client *Client) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	for n := 0; ; n++ {
+		err := client.call(serviceMethod, args, reply)
+		delay, throttled := Throttled(err, n)
+		if !throttled || n >= MaxThrottled {
+			return err
+		}
+		time.Sleep(delay)
+	}
+}
+
+func (client *Client) call(serviceMethod string, args interface{}, reply interface{}) error {
 	client.Lock()
 	rpcClient := client.Client
 	if rpcClient == nil {
@@ -124,7 +148,80 @@ func (client *Client) Call(serviceMethod string, args interface{}, reply interfa
 		rpcClient = c
 	}
 	client.Unlock()
-	return rpcClient.Call(serviceMethod, args, reply)
+	args, proving, err := client.prove(rpcClient, serviceMethod, args)
+	if err != nil {
+		return err
+	}
+	err = rpcClient.Call(serviceMethod, args, reply)
+	if err == nil {
+		switch {
+		case proving:
+			client.Lock()
+			client.proven = rpcClient
+			client.Unlock()
+		case serviceMethod == "Signaling.Leave" || serviceMethod == "Signaling.DestroyRoom":
+			client.Lock()
+			client.proven = nil
+			client.Unlock()
+		}
+	}
+	if err == rpc.ErrShutdown {
+		// connection lost (e.g. server restarted): redial on next call.
+		client.Lock()
+		if client.Client == rpcClient {
+			client.Client = nil
+		}
+		client.Unlock()
+	}
+	return err
+}
+
+// WaitAdmission waits for the owner of a room to decide on our knock,
+// after a Join failed with signaling.ErrWaiting. It returns nil once we
+// may join again, signaling.ErrDenied when the owner said no.
+func (client *Client) WaitAdmission(req signaling.Request) error {
+	deadline := time.Now().Add(signaling.KnockTTL)
+	for time.Now().Before(deadline) {
+		events := []*signaling.Event{}
+		if err := client.Call("Signaling.Pull", req, &events); err != nil {
+			return err
+		}
+		for _, ev := range events {
+			if a, ok := ev.Get().(*signaling.Admission); ok {
+				if !a.Approved {
+					return signaling.ErrDenied
+				}
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("no admission for %s", req.UserID)
+}
+
+// prove answers a challenge in the first Join or CreateRoom of a
+// connection when the UserID is derived from Key.
+func (client *Client) prove(c *rpc.Client, serviceMethod string, args interface{}) (interface{}, bool, error) {
+	req, ok := args.(signaling.Request)
+	if !ok || client.config.Key == nil {
+		return args, false, nil
+	}
+	switch serviceMethod {
+	case "Signaling.Join", "Signaling.CreateRoom":
+	default:
+		return args, false, nil
+	}
+	client.RLock()
+	proven := client.proven == c
+	client.RUnlock()
+	if proven {
+		return args, false, nil
+	}
+	var challenge signaling.Challenge
+	if err := c.Call("Signaling.Challenge", req, &challenge); err != nil {
+		return nil, false, err
+	}
+	signaling.Prove(&req, client.config.Key, &challenge)
+	return req, true, nil
 }
 
 // Close ...
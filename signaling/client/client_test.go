@@ -0,0 +1,63 @@
+package client
+
+import (
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"testing"
+	"time"
+
+	"github.com/nobonobo/p2pfw/signaling"
+)
+
+// Throttler fails the first Calls with a rate limit error.
+type Throttler struct {
+	Calls int
+}
+
+// Echo ...
+func (t *Throttler) Echo(s string, reply *string) error {
+	t.Calls--
+	if t.Calls >= 0 {
+		return &signaling.RateLimitError{Scope: "user", RetryAfter: time.Millisecond}
+	}
+	*reply = s
+	return nil
+}
+
+func TestCallThrottled(t *testing.T) {
+	defer func(d time.Duration, max int) { ThrottleDelay, MaxThrottled = d, max }(ThrottleDelay, MaxThrottled)
+	ThrottleDelay, MaxThrottled = time.Millisecond, 2
+	tests := []struct {
+		throttled int
+		fail      bool
+	}{
+		{0, false},
+		{2, false},
+		{3, true},
+	}
+	for _, tt := range tests {
+		th := &Throttler{Calls: tt.throttled}
+		server := rpc.NewServer()
+		if err := server.Register(th); err != nil {
+			t.Fatal(err)
+		}
+		client := &Client{
+			dialer: func() (*rpc.Client, error) {
+				c, s := net.Pipe()
+				go server.ServeCodec(jsonrpc.NewServerCodec(s))
+				return jsonrpc.NewClient(c), nil
+			},
+			config: new(Config),
+		}
+		var reply string
+		err := client.Call("Throttler.Echo", "hi", &reply)
+		client.Close()
+		if _, limited := signaling.AsRateLimitError(err); limited != tt.fail {
+			t.Errorf("%d throttled: %v, want failure %v", tt.throttled, err, tt.fail)
+		}
+		if !tt.fail && reply != "hi" {
+			t.Errorf("%d throttled: reply %q", tt.throttled, reply)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"crypto/ed25519"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nobonobo/p2pfw/signaling"
+)
+
+func TestLoadKey(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "id.pem")
+	key, err := LoadKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("key file %v %v", fi.Mode(), err)
+	}
+	again, err := LoadKey(path)
+	if err != nil || !again.Equal(key) {
+		t.Fatalf("reloaded %v, %v", err, again.Equal(key))
+	}
+	bad := filepath.Join(dir, "bad.pem")
+	if err := ioutil.WriteFile(bad, []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadKey(bad); err == nil {
+		t.Errorf("loaded %s", bad)
+	}
+	id := signaling.KeyUserID(key.Public().(ed25519.PublicKey))
+	tests := []struct {
+		user string
+		fail bool
+	}{
+		{"", false},
+		{id, false},
+		{"alice", true},
+	}
+	for _, tt := range tests {
+		config := &Config{Key: key, URL: "ws://localhost/ws"}
+		config.UserID = tt.user
+		_, err := New(config)
+		if (err != nil) != tt.fail || err == nil && config.UserID != id {
+			t.Errorf("user %q: %v, UserID %q", tt.user, err, config.UserID)
+		}
+	}
+}
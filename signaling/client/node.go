@@ -1,6 +1,8 @@
 package client
 
 import (
+	"time"
+
 	"github.com/nobonobo/p2pfw/signaling"
 )
 
@@ -43,25 +45,61 @@ func NewNode(config *Config) (*Node, error) {
 
 func (n *Node) run(dispatchers ...Dispatcher) {
 	defer close(n.done)
+	var rc Reconnector
 	for {
-		if err := n.rpcClient.Call("Signaling.Join", n.r, None); err != nil {
+		err := n.rpcClient.Call("Signaling.Join", n.r, None)
+		if err == nil {
+			rc.Connected()
+			events := new([]*signaling.Event)
+			call := n.rpcClient.Go("Signaling.Pull", n.r, &events, nil)
+			select {
+			case <-n.closing:
+				return
+			case <-call.Done:
+			}
+			if err = call.Error; err == nil {
+				for _, d := range dispatchers {
+					d.Dispatch(*events)
+				}
+				if delay, ok := rc.GoingAway(*events); ok && !n.reconnect(delay) {
+					return
+				}
+				continue
+			}
+		}
+		_, throttled := signaling.AsRateLimitError(err)
+		delay, err := rc.Failed(err)
+		if err != nil {
 			n.done <- err
 			return
 		}
-		events := new([]*signaling.Event)
-		call := n.rpcClient.Go("Signaling.Pull", n.r, &events, nil)
-		select {
-		case <-n.closing:
-			return
-		case <-call.Done:
-			if call.Error != nil {
-				n.done <- call.Error
+		if throttled {
+			// the connection is fine, only too busy.
+			if !n.wait(delay) {
 				return
 			}
-			for _, d := range dispatchers {
-				d.Dispatch(*events)
-			}
+			continue
 		}
+		if !n.reconnect(delay) {
+			return
+		}
+	}
+}
+
+// reconnect drops the connection and waits delay, it returns false when
+// the node stops meanwhile.
+func (n *Node) reconnect(delay time.Duration) bool {
+	n.rpcClient.Close()
+	return n.wait(delay)
+}
+
+// wait returns after delay, or false when the node stops meanwhile.
+func (n *Node) wait(delay time.Duration) bool {
+	select {
+	case <-n.closing:
+		return false
+	case <-time.After(delay):
+		return true
 	}
 }
 
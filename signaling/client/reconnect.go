@@ -0,0 +1,94 @@
+package client
+
+import (
+	"io"
+	"net/rpc"
+	"time"
+
+	"github.com/nobonobo/p2pfw/signaling"
+)
+
+var (
+	// ReconnectDelay is the first wait before redialing when the server
+	// suggests none, it doubles on each failed attempt.
+	ReconnectDelay = time.Second
+	// MaxReconnects bounds the failed attempts in a row.
+	MaxReconnects = 6
+	// ThrottleDelay is the first wait before repeating a rate limited call
+	// when the server asks for less, it doubles on each retry.
+	ThrottleDelay = 100 * time.Millisecond
+	// MaxThrottled bounds the retries of a rate limited call.
+	MaxThrottled = 4
+)
+
+// Retryable reports whether err comes from a dropped connection or a
+// draining server, so that a new connection may succeed.
+func Retryable(err error) bool {
+	return err == rpc.ErrShutdown || err == io.EOF || err == io.ErrUnexpectedEOF ||
+		signaling.IsError(err, signaling.ErrGoingAway)
+}
+
+// Throttled returns the wait before retry n (from 0) of a call which
+// failed with a signaling.RateLimitError, false for other errors.
+func Throttled(err error, n int) (time.Duration, bool) {
+	e, ok := signaling.AsRateLimitError(err)
+	if !ok {
+		return 0, false
+	}
+	d := ThrottleDelay << uint(n)
+	if d < e.RetryAfter {
+		d = e.RetryAfter
+	}
+	return d, true
+}
+
+// Reconnector paces the redials of a node after a GoingAway event or a
+// lost connection.
+type Reconnector struct {
+	delay time.Duration // next wait, 0 while connected
+	tries int
+}
+
+// GoingAway looks for a GoingAway event in events and returns the wait
+// before reconnecting.
+func (r *Reconnector) GoingAway(events []*signaling.Event) (time.Duration, bool) {
+	for _, ev := range events {
+		if g, ok := ev.Get().(*signaling.GoingAway); ok {
+			r.delay = g.Reconnect
+			if r.delay <= 0 {
+				r.delay = ReconnectDelay
+			}
+			return r.delay, true
+		}
+	}
+	return 0, false
+}
+
+// Failed returns the wait before the next attempt after err, or err when
+// it is not worth another one. Once reconnecting any error is retried,
+// rate limited attempts wait as long as the server asks.
+func (r *Reconnector) Failed(err error) (time.Duration, error) {
+	if r.tries >= MaxReconnects {
+		return 0, err
+	}
+	if d, ok := Throttled(err, r.tries); ok {
+		r.tries++
+		return d, nil
+	}
+	if r.delay == 0 && !Retryable(err) {
+		return 0, err
+	}
+	switch {
+	case r.delay == 0:
+		r.delay = ReconnectDelay
+	case r.tries > 0:
+		r.delay *= 2
+	}
+	r.tries++
+	return r.delay, nil
+}
+
+// Connected resets r after a successful Join.
+func (r *Reconnector) Connected() {
+	r.delay, r.tries = 0, 0
+}
@@ -0,0 +1,79 @@
+package client
+
+import (
+	"errors"
+	"net/rpc"
+	"testing"
+	"time"
+
+	"github.com/nobonobo/p2pfw/signaling"
+)
+
+func TestReconnector(t *testing.T) {
+	defer func(d, t time.Duration, max int) {
+		ReconnectDelay, ThrottleDelay, MaxReconnects = d, t, max
+	}(ReconnectDelay, ThrottleDelay, MaxReconnects)
+	ReconnectDelay, ThrottleDelay, MaxReconnects = time.Second, 100*time.Millisecond, 3
+	away := []*signaling.Event{signaling.New("", "", &signaling.GoingAway{Reconnect: 5 * time.Second})}
+	refused := errors.New("dial tcp: connection refused")
+	throttled := errors.New("rate limited: user, retry after 250ms") // as an rpc.ServerError
+	type step struct {
+		events []*signaling.Event // GoingAway, else err fails the call
+		err    error
+		join   bool // a Join succeeds instead
+		want   time.Duration
+		fail   bool
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"fatal error", []step{{err: errors.New("banned user: me"), fail: true}}},
+		{"dropped connection", []step{
+			{err: rpc.ErrShutdown, want: time.Second},
+			{err: refused, want: 2 * time.Second},
+			{err: refused, want: 4 * time.Second},
+			{err: refused, fail: true},
+		}},
+		{"draining server", []step{{err: signaling.ErrGoingAway, want: time.Second}}},
+		{"going away", []step{
+			{events: away, want: 5 * time.Second},
+			{err: refused, want: 5 * time.Second},
+			{err: refused, want: 10 * time.Second},
+			{join: true},
+			{err: errors.New("room is full"), fail: true},
+		}},
+		{"throttled", []step{
+			{err: throttled, want: 250 * time.Millisecond},
+			{err: throttled, want: 250 * time.Millisecond},
+			{err: throttled, want: 400 * time.Millisecond},
+			{err: throttled, fail: true},
+		}},
+		{"throttled while reconnecting", []step{
+			{err: rpc.ErrShutdown, want: time.Second},
+			{err: throttled, want: 250 * time.Millisecond},
+			{err: refused, want: 2 * time.Second},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rc Reconnector
+			for i, s := range tt.steps {
+				var got time.Duration
+				var err error
+				switch {
+				case s.join:
+					rc.Connected()
+					continue
+				case s.events != nil:
+					got, _ = rc.GoingAway(s.events)
+				default:
+					got, err = rc.Failed(s.err)
+				}
+				if (err != nil) != s.fail || got != s.want {
+					t.Errorf("step %d: got %s, %v, want %s, failure %v", i, got, err, s.want, s.fail)
+				}
+			}
+		})
+	}
+}
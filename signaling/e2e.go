@@ -0,0 +1,220 @@
+package signaling
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// PublicKey announces the E2E key of Member. MAC is keyed by the room
+// secret, so it only guards against parties not knowing the secret: the
+// signaling server is given the secret at every request, and can swap
+// the keys of members to read and forge their sealed events.
+type PublicKey struct {
+	Member string
+	Key    []byte
+	MAC    []byte
+}
+
+// Kind ...
+func (c *PublicKey) Kind() string { return "e2e-key" }
+
+// Sealed is an event encrypted and authenticated for its recipient.
+type Sealed struct {
+	Nonce []byte
+	Box   []byte
+}
+
+// Kind ...
+func (c *Sealed) Kind() string { return "e2e" }
+
+type sealedBody struct {
+	From  string
+	To    string
+	Kind  string
+	Value json.RawMessage
+}
+
+// clearKinds may reach a member unsealed while E2E is on: the keys
+// themselves and the events of the server. Any other kind is dropped
+// unless sealed, broadcast or not.
+var clearKinds = map[string]bool{
+	(&PublicKey{}).Kind(): true,
+	(&Join{}).Kind():      true,
+	(&Leave{}).Kind():     true,
+	(&Kicked{}).Kind():    true,
+	(&Knock{}).Kind():     true,
+	(&Admission{}).Kind(): true,
+	(&GoingAway{}).Kind(): true,
+}
+
+// E2E holds the key pair of a local user in a room and the keys of the
+// other members. A member's key is pinned on first use until Forget,
+// e.g. when it leaves the room. It hides the events from a passive
+// server, not from one tampering with the keys, see PublicKey.
+type E2E struct {
+	user    string
+	public  *[32]byte
+	private *[32]byte
+	mac     []byte
+	mu      sync.Mutex
+	peers   map[string]*[32]byte
+}
+
+func macKey(room, preshared string) []byte {
+	mac := hmac.New(sha256.New, []byte(preshared))
+	io.WriteString(mac, "p2pfw e2e key\x00"+room)
+	return mac.Sum(nil)
+}
+
+func keyMAC(key []byte, member string, public []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	io.WriteString(mac, member+"\x00")
+	mac.Write(public)
+	return mac.Sum(nil)
+}
+
+// NewE2E generates a key pair for user in room.
+func NewE2E(room, user, preshared string) (*E2E, error) {
+	public, private, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &E2E{
+		user:    user,
+		public:  public,
+		private: private,
+		mac:     macKey(room, preshared),
+		peers:   map[string]*[32]byte{},
+	}, nil
+}
+
+// PublicKey returns our key, to be sent to the other members.
+func (e *E2E) PublicKey() *PublicKey {
+	return &PublicKey{
+		Member: e.user,
+		Key:    e.public[:],
+		MAC:    keyMAC(e.mac, e.user, e.public[:]),
+	}
+}
+
+// Accept verifies pk received from the member from and pins it. It
+// reports whether the key was not known before, and fails when from
+// announces another key than the pinned one.
+func (e *E2E) Accept(from string, pk *PublicKey) (bool, error) {
+	if pk.Member != from || len(pk.Key) != 32 {
+		return false, fmt.Errorf("invalid e2e key from %s", from)
+	}
+	if !hmac.Equal(pk.MAC, keyMAC(e.mac, pk.Member, pk.Key)) {
+		return false, fmt.Errorf("e2e key mac mismatch from %s", from)
+	}
+	key := new([32]byte)
+	copy(key[:], pk.Key)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if old := e.peers[from]; old != nil {
+		if *old != *key {
+			return false, fmt.Errorf("e2e key of %s changed", from)
+		}
+		return false, nil
+	}
+	e.peers[from] = key
+	return true, nil
+}
+
+// Forget unpins the key of member.
+func (e *E2E) Forget(member string) {
+	e.mu.Lock()
+	delete(e.peers, member)
+	e.mu.Unlock()
+}
+
+func (e *E2E) peer(member string) *[32]byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.peers[member]
+}
+
+// Seal encrypts ev for its recipient. Broadcasts and keys pass as they
+// are, only clearKinds of them are opened by the other members. Other
+// events fail when the key of the recipient is unknown.
+func (e *E2E) Seal(ev *Event) (*Event, error) {
+	if ev.To == "" || ev.Kind == (&PublicKey{}).Kind() {
+		return ev, nil
+	}
+	if ev.From != e.user {
+		return nil, fmt.Errorf("e2e seal from %s by %s", ev.From, e.user)
+	}
+	peer := e.peer(ev.To)
+	if peer == nil {
+		return nil, fmt.Errorf("no e2e key for %s", ev.To)
+	}
+	body, err := json.Marshal(&sealedBody{From: ev.From, To: ev.To, Kind: ev.Kind, Value: ev.Value})
+	if err != nil {
+		return nil, err
+	}
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	sealed := &Sealed{
+		Nonce: nonce[:],
+		Box:   box.Seal(nil, body, &nonce, peer, e.private),
+	}
+	out := &Event{From: ev.From, To: ev.To, Kind: sealed.Kind()}
+	out.Value, err = json.Marshal(sealed)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Open decrypts a sealed ev. Unsealed events pass when they are of
+// clearKinds, other ones are rejected.
+func (e *E2E) Open(ev *Event) (*Event, error) {
+	if !ev.Sealed() {
+		if clearKinds[ev.Kind] {
+			return ev, nil
+		}
+		return nil, fmt.Errorf("unsealed %s from %s", ev.Kind, ev.From)
+	}
+	if ev.To != e.user {
+		return nil, fmt.Errorf("e2e event for %s", ev.To)
+	}
+	peer := e.peer(ev.From)
+	if peer == nil {
+		return nil, fmt.Errorf("no e2e key for %s", ev.From)
+	}
+	sealed := new(Sealed)
+	if err := json.Unmarshal(ev.Value, sealed); err != nil {
+		return nil, err
+	}
+	if len(sealed.Nonce) != 24 {
+		return nil, fmt.Errorf("invalid e2e nonce")
+	}
+	var nonce [24]byte
+	copy(nonce[:], sealed.Nonce)
+	b, ok := box.Open(nil, sealed.Box, &nonce, peer, e.private)
+	if !ok {
+		return nil, fmt.Errorf("e2e open failed from %s", ev.From)
+	}
+	body := new(sealedBody)
+	if err := json.Unmarshal(b, body); err != nil {
+		return nil, err
+	}
+	if body.From != ev.From || body.To != ev.To {
+		return nil, fmt.Errorf("e2e sender mismatch: %s", ev.From)
+	}
+	return &Event{From: body.From, To: body.To, Kind: body.Kind, Value: body.Value}, nil
+}
+
+func init() {
+	Register(func() Kinder { return new(PublicKey) })
+	Register(func() Kinder { return new(Sealed) })
+}
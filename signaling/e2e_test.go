@@ -0,0 +1,109 @@
+package signaling
+
+import (
+	"testing"
+)
+
+func newE2E(t *testing.T, user, preshared string) *E2E {
+	t.Helper()
+	e, err := NewE2E("room", user, preshared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestE2EAccept(t *testing.T) {
+	alice := newE2E(t, "alice", "secret")
+	bob := newE2E(t, "bob", "secret")
+	bob2 := newE2E(t, "bob", "secret")
+	mallory := newE2E(t, "bob", "guess")
+	renamed := bob.PublicKey()
+	renamed.Member = "carol"
+	tests := []struct {
+		name   string
+		from   string
+		pk     *PublicKey
+		forget bool // bob's key before
+		added  bool
+		fail   bool
+	}{
+		{"first key", "bob", bob.PublicKey(), false, true, false},
+		{"same key", "bob", bob.PublicKey(), false, false, false},
+		{"changed key", "bob", bob2.PublicKey(), false, false, true},
+		{"wrong secret", "bob", mallory.PublicKey(), true, false, true},
+		{"other member", "bob", renamed, false, false, true},
+		{"after leaving", "bob", bob2.PublicKey(), true, true, false},
+	}
+	for _, tt := range tests {
+		if tt.forget {
+			alice.Forget("bob")
+		}
+		added, err := alice.Accept(tt.from, tt.pk)
+		if added != tt.added || (err != nil) != tt.fail {
+			t.Errorf("%s: added %v, %v", tt.name, added, err)
+		}
+	}
+}
+
+func TestE2ESeal(t *testing.T) {
+	alice := newE2E(t, "alice", "secret")
+	bob := newE2E(t, "bob", "secret")
+	carol := newE2E(t, "carol", "secret")
+	for _, pair := range [][2]*E2E{{alice, bob}, {bob, alice}, {carol, alice}} {
+		if _, err := pair[0].Accept(pair[1].user, pair[1].PublicKey()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	offer := New("alice", "bob", &Join{Member: "offer"})
+	tests := []struct {
+		name     string
+		ev       *Event
+		sender   *E2E
+		receiver *E2E
+		sealed   bool
+		sendFail bool
+		recvFail bool
+	}{
+		{"directed", offer, alice, bob, true, false, false},
+		{"broadcast", New("alice", "", &Join{Member: "alice"}), alice, bob, false, false, false},
+		{"unsealed broadcast", New("alice", "", &peerEvent{}), alice, bob, false, false, true},
+		{"key", New("alice", "bob", alice.PublicKey()), alice, bob, false, false, false},
+		{"unknown recipient", New("alice", "carol", &Join{}), alice, carol, false, true, false},
+		{"not ours", New("bob", "carol", &Join{}), alice, carol, false, true, false},
+		{"wrong recipient", offer, alice, carol, true, false, true},
+		{"unsealed directed", New("alice", "bob", &peerEvent{}), nil, bob, false, false, true},
+		{"unsealed server event", New("", "bob", &GoingAway{}), nil, bob, false, false, false},
+		{"unsealed kick", New("alice", "bob", &Kicked{Member: "bob"}), nil, bob, false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := tt.ev
+			if tt.sender != nil {
+				sealed, err := tt.sender.Seal(ev)
+				if (err != nil) != tt.sendFail {
+					t.Fatalf("seal: %v", err)
+				}
+				if err != nil {
+					return
+				}
+				if sealed.Sealed() != tt.sealed {
+					t.Fatalf("sealed %v, want %v", sealed.Sealed(), tt.sealed)
+				}
+				ev = sealed
+			}
+			opened, err := tt.receiver.Open(ev)
+			if (err != nil) != tt.recvFail {
+				t.Fatalf("open: %v", err)
+			}
+			if err == nil && (opened.Kind != tt.ev.Kind || string(opened.Value) != string(tt.ev.Value)) {
+				t.Errorf("opened %+v, want %+v", opened, tt.ev)
+			}
+		})
+	}
+}
+
+// peerEvent stands for an event between members in the tests.
+type peerEvent struct{}
+
+func (c *peerEvent) Kind() string { return "peer" }
@@ -1,6 +1,9 @@
 package signaling
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"time"
+)
 
 // Kinder ...
 type Kinder interface {
@@ -34,6 +37,11 @@ func New(from, to string, value Kinder) *Event {
 	return ev
 }
 
+// Sealed reports whether ev is encrypted end to end.
+func (ev *Event) Sealed() bool {
+	return ev.Kind == (&Sealed{}).Kind()
+}
+
 // Get ...
 func (ev *Event) Get() Kinder {
 	var value Kinder
@@ -62,7 +70,47 @@ type Leave struct {
 // Kind ...
 func (c *Leave) Kind() string { return "leave" }
 
+// Kicked ...
+type Kicked struct {
+	Member string
+	Reason string
+	Banned bool
+	Until  time.Time // zero means forever when Banned
+}
+
+// Kind ...
+func (c *Kicked) Kind() string { return "kicked" }
+
+// Knock ...
+type Knock struct {
+	Member string
+}
+
+// Kind ...
+func (c *Knock) Kind() string { return "knock" }
+
+// Admission tells a knocking user the owner's decision.
+type Admission struct {
+	Member   string
+	Approved bool
+}
+
+// Kind ...
+func (c *Admission) Kind() string { return "admission" }
+
+// GoingAway is sent to every member when the server shuts down.
+type GoingAway struct {
+	Reconnect time.Duration // suggested delay before reconnecting
+}
+
+// Kind ...
+func (c *GoingAway) Kind() string { return "server-going-away" }
+
 func init() {
 	Register(func() Kinder { return new(Join) })
 	Register(func() Kinder { return new(Leave) })
+	Register(func() Kinder { return new(Kicked) })
+	Register(func() Kinder { return new(Knock) })
+	Register(func() Kinder { return new(Admission) })
+	Register(func() Kinder { return new(GoingAway) })
 }
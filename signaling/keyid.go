@@ -0,0 +1,56 @@
+package signaling
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"strings"
+	"time"
+)
+
+// KeyPrefix marks a UserID derived from an Ed25519 public key.
+const KeyPrefix = "ed25519:"
+
+// ChallengeTTL ...
+const ChallengeTTL = 30 * time.Second
+
+// KeyUserID returns the stable UserID of the holder of pub.
+func KeyUserID(pub ed25519.PublicKey) string {
+	return KeyPrefix + base64.RawURLEncoding.EncodeToString(pub)
+}
+
+// UserKey returns the public key user is derived from, if any.
+func UserKey(user string) (ed25519.PublicKey, bool) {
+	if !strings.HasPrefix(user, KeyPrefix) {
+		return nil, false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(user, KeyPrefix))
+	if err != nil || len(b) != ed25519.PublicKeySize {
+		return nil, false
+	}
+	return ed25519.PublicKey(b), true
+}
+
+// Challenge is the reply of Signaling.Challenge.
+type Challenge struct {
+	Nonce   []byte
+	Expires time.Time
+}
+
+// Proof answers a Challenge: Sig signs ProofMessage with the key of UserID.
+type Proof struct {
+	Nonce []byte
+	Sig   []byte
+}
+
+// ProofMessage ...
+func ProofMessage(room, user string, nonce []byte) []byte {
+	return []byte(strings.Join([]string{"p2pfw-join", room, user, string(nonce)}, "\x00"))
+}
+
+// Prove answers challenge for req with key.
+func Prove(req *Request, key ed25519.PrivateKey, challenge *Challenge) {
+	req.Proof = &Proof{
+		Nonce: challenge.Nonce,
+		Sig:   ed25519.Sign(key, ProofMessage(req.RoomID, req.UserID, challenge.Nonce)),
+	}
+}
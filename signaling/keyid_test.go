@@ -0,0 +1,30 @@
+package signaling
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+)
+
+func TestUserKey(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		user string
+		ok   bool
+	}{
+		{KeyUserID(pub), true},
+		{"alice", false},
+		{KeyPrefix + "not base64!", false},
+		{KeyPrefix + "AAAA", false}, // too short
+		{KeyUserID(pub) + "AA", false},
+	}
+	for _, tt := range tests {
+		key, ok := UserKey(tt.user)
+		if ok != tt.ok || ok && !key.Equal(pub) {
+			t.Errorf("%q: %v %x", tt.user, ok, key)
+		}
+	}
+}
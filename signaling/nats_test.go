@@ -0,0 +1,198 @@
+package signaling
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// natsServer is a stand-in NATS server, enough for NATSBroker: it
+// checks the CONNECT auth and routes PUB to the SUB of every client.
+type natsServer struct {
+	l     net.Listener
+	user  string
+	pass  string
+	token string
+	mu    sync.Mutex
+	subs  map[net.Conn]map[string]string // sid to subject
+}
+
+func newNATSServer(t *testing.T, user, pass, token string) *natsServer {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &natsServer{l: l, user: user, pass: pass, token: token, subs: map[net.Conn]map[string]string{}}
+	t.Cleanup(func() { l.Close(); s.drop() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *natsServer) url(userinfo string) string {
+	return "nats://" + userinfo + s.l.Addr().String()
+}
+
+// drop closes the connections of all clients.
+func (s *natsServer) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.subs {
+		conn.Close()
+		delete(s.subs, conn)
+	}
+}
+
+func (s *natsServer) serve(conn net.Conn) {
+	defer conn.Close()
+	fmt.Fprintf(conn, "INFO {\"server_id\":\"test\",\"auth_required\":true}\r\n")
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			break
+		}
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+		switch args[0] {
+		case "CONNECT":
+			var options natsConnect
+			json.Unmarshal([]byte(strings.TrimPrefix(line, "CONNECT ")), &options)
+			if options.User != s.user || options.Pass != s.pass || options.AuthToken != s.token {
+				io.WriteString(conn, "-ERR 'Authorization Violation'\r\n")
+				return
+			}
+			s.mu.Lock()
+			s.subs[conn] = map[string]string{}
+			s.mu.Unlock()
+		case "PING":
+			s.mu.Lock()
+			io.WriteString(conn, "PONG\r\n")
+			s.mu.Unlock()
+		case "SUB":
+			s.mu.Lock()
+			if subs := s.subs[conn]; subs != nil {
+				subs[args[2]] = args[1]
+			}
+			s.mu.Unlock()
+		case "UNSUB":
+			s.mu.Lock()
+			delete(s.subs[conn], args[1])
+			s.mu.Unlock()
+		case "PUB":
+			n, _ := strconv.Atoi(args[2])
+			payload := make([]byte, n+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			s.mu.Lock()
+			for c, subs := range s.subs {
+				for sid, subject := range subs {
+					if subject == args[1] {
+						fmt.Fprintf(c, "MSG %s %s %d\r\n%s", subject, sid, n, payload)
+					}
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+	s.mu.Lock()
+	delete(s.subs, conn)
+	s.mu.Unlock()
+}
+
+func TestDialNATS(t *testing.T) {
+	withPass := newNATSServer(t, "u", "p", "")
+	withToken := newNATSServer(t, "", "", "t")
+	tests := []struct {
+		name string
+		url  string
+		fail bool
+	}{
+		{"user and password", withPass.url("u:p@"), false},
+		{"wrong password", withPass.url("u:x@"), true},
+		{"no credentials", withPass.url(""), true},
+		{"token", withToken.url("t@"), false},
+		{"wrong token", withToken.url("x@"), true},
+	}
+	for _, tt := range tests {
+		b, err := DialNATS(tt.url)
+		if (err != nil) != tt.fail {
+			t.Errorf("%s: %v, want failure %v", tt.name, err, tt.fail)
+		}
+		if err == nil {
+			b.Close()
+		}
+	}
+}
+
+func TestNATSBroker(t *testing.T) {
+	defer func(wait time.Duration) { NATSReconnectWait = wait }(NATSReconnectWait)
+	NATSReconnectWait = 10 * time.Millisecond
+	s := newNATSServer(t, "", "", "")
+	dial := func() *NATSBroker {
+		b, err := DialNATS(s.url(""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { b.Close() })
+		return b
+	}
+	a, b := dial(), dial()
+	got := make(chan string, 16)
+	unsub, err := b.Subscribe("room", func(env *Envelope) { got <- env.Origin })
+	if err != nil {
+		t.Fatal(err)
+	}
+	// publishes from a until b receives it, both redial after a drop.
+	deliver := func(origin string) bool {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+			a.Publish("room", &Envelope{Origin: origin})
+			select {
+			case o := <-got:
+				if o == origin {
+					return true
+				}
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+		return false
+	}
+	if !deliver("before") {
+		t.Fatal("not delivered")
+	}
+	s.drop()
+	if !deliver("after drop") {
+		t.Fatal("not delivered after reconnect")
+	}
+	unsub()
+	a.Publish("room", &Envelope{Origin: "unsubscribed"})
+	select {
+	case o := <-got:
+		if o == "unsubscribed" {
+			t.Error("delivered after unsubscribe")
+		}
+	case <-time.After(100 * time.Millisecond):
+	}
+	b.Close()
+	if _, err := b.Subscribe("room", func(*Envelope) {}); err == nil {
+		t.Error("subscribed after close")
+	}
+}
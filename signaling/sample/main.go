@@ -40,10 +40,12 @@ func main() {
 	join := ""
 	user := "unknown"
 	secret := ""
+	keyFile := ""
 	flag.StringVar(&create, "c", create, "create room id")
 	flag.StringVar(&join, "j", join, "join room id")
 	flag.StringVar(&user, "u", user, "user id")
 	flag.StringVar(&secret, "s", secret, "preshared secret")
+	flag.StringVar(&keyFile, "k", keyFile, "identity key file (user id derived from it)")
 	flag.Parse()
 	if len(create) > 0 && len(join) > 0 {
 		log.Fatalln("only create or join")
@@ -53,6 +55,14 @@ func main() {
 	config.RoomID = room
 	config.UserID = user
 	config.Preshared = secret
+	if len(keyFile) > 0 {
+		key, err := client.LoadKey(keyFile)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		config.Key = key
+		config.UserID = ""
+	}
 	node, err := client.NewNode(config)
 	if err != nil {
 		log.Fatalln(err)
@@ -70,6 +80,7 @@ func main() {
 			fmt.Print(PROMPT)
 		}
 	})
+	user = config.UserID
 	if err := node.Start(len(create) > 0, receiver); err != nil {
 		log.Fatalln(err)
 	}
@@ -1,25 +1,114 @@
 package signaling
 
 import (
+	"crypto/hmac"
+	"errors"
 	"fmt"
 	"log"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
-const (
+var (
 	// N ...
 	N = 1024
 	// TIMEOUT ...
 	TIMEOUT = 30 * time.Second
+	// Verbose ...
+	Verbose = true
+	// MaxWaiting caps the users knocking on a room at once.
+	MaxWaiting = 64
+	// KnockTTL is how long a knock waits for the owner, and an approved
+	// user has to join.
+	KnockTTL = 5 * time.Minute
+	// DenyTTL is how long a denied user can't knock again.
+	DenyTTL = 10 * time.Minute
+)
+
+var (
+	// ErrLocked ...
+	ErrLocked = errors.New("room is locked")
+	// ErrFull ...
+	ErrFull = errors.New("room is full")
+	// ErrWaiting ...
+	ErrWaiting = errors.New("waiting for approval")
+	// ErrDenied ...
+	ErrDenied = errors.New("join denied")
+	// ErrQueueFull ...
+	ErrQueueFull = errors.New("too many waiting for approval")
+	// ErrGoingAway is returned by a draining server, see GoingAway.
+	ErrGoingAway = errors.New("server is going away")
+)
+
+// IsError reports whether err is target, also across the rpc boundary
+// where errors arrive as rpc.ServerError strings.
+func IsError(err, target error) bool {
+	return err != nil && err.Error() == target.Error()
+}
+
+type admission int
+
+const (
+	pending admission = iota
+	approved
+	denied
 )
 
+// waiter is a user knocking on a room, or the owner's decision on it
+// until expires.
+type waiter struct {
+	state   admission
+	expires time.Time
+	event   chan *Event // the Admission, see Room.Wait
+}
+
+// RateLimitError is returned when a request exceeds a rate limit of Scope
+// ("conn", "user", "room", "create" or "verify").
+type RateLimitError struct {
+	Scope      string
+	RetryAfter time.Duration
+}
+
+const rateLimitPrefix = "rate limited: "
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s%s, retry after %s", rateLimitPrefix, e.Scope, e.RetryAfter)
+}
+
+// AsRateLimitError parses err, also when it arrived as an rpc.ServerError.
+func AsRateLimitError(err error) (*RateLimitError, bool) {
+	if err == nil {
+		return nil, false
+	}
+	if e, ok := err.(*RateLimitError); ok {
+		return e, true
+	}
+	msg := err.Error()
+	if !strings.HasPrefix(msg, rateLimitPrefix) {
+		return nil, false
+	}
+	parts := strings.SplitN(msg[len(rateLimitPrefix):], ", retry after ", 2)
+	if len(parts) != 2 {
+		return nil, false
+	}
+	d, err := time.ParseDuration(parts[1])
+	if err != nil {
+		return nil, false
+	}
+	return &RateLimitError{Scope: parts[0], RetryAfter: d}, true
+}
+
 // Member ...
 type Member struct {
 	sync.RWMutex
 	UserID string
 	event  chan *Event
 	timer  *time.Timer
+	secret []byte // memberDigest of the preshared joined with
+
+	announced time.Time // last Presence published to other instances
 }
 
 // Push ...
@@ -30,7 +119,9 @@ func (m *Member) Push(event *Event) {
 	for {
 		select {
 		case ch <- event:
-			log.Println("push:", m.UserID, event)
+			if Verbose {
+				log.Println("push:", m.UserID, event)
+			}
 			return
 		default:
 		}
@@ -68,33 +159,176 @@ func (m *Member) Close() {
 type Room struct {
 	name      string
 	owner     string
-	preshared string
+	preshared string // hash, see HashPreshared
 	sync.RWMutex
 	members map[string]*Member
+	bans    map[string]time.Time
+	waiting map[string]*waiter
 	check   func()
 	locked  bool
+	knock   bool
+	limit   int
+	public  bool
+	title   string
+	tags    []string
+	kinds   map[string]bool // allowed Event.Kind of Send, nil means any
+	broker  Broker
+	origin  string
+	replica bool
+	unsub   func()
+	remote  map[string]time.Time // members on other instances, until
+
+	returning map[string]bool // members before a restart, see RestoreRoom
+	restoring time.Time       // the end of the restart for returning
+	changed   func()
 }
 
 // NewRoom ...
-func NewRoom(name, owner, preshared string) *Room {
+func NewRoom(name, owner, preshared string) (*Room, error) {
+	hash, err := HashPreshared(preshared)
+	if err != nil {
+		return nil, err
+	}
 	room := &Room{
 		name:      name,
 		owner:     owner,
-		preshared: preshared,
+		preshared: hash,
+		members:   map[string]*Member{},
+		bans:      map[string]time.Time{},
+		waiting:   map[string]*waiter{},
+		remote:    map[string]time.Time{},
+	}
+	room.Join(Request{RoomID: name, UserID: owner, Preshared: preshared})
+	return room, nil
+}
+
+// RestoreRoom rebuilds a room from its persisted state. Its members are
+// let in again within TIMEOUT, and until then the room waits for its
+// owner.
+func RestoreRoom(state *RoomState) *Room {
+	room := &Room{
+		name:      state.Name,
+		owner:     state.Owner,
+		preshared: state.Preshared,
 		members:   map[string]*Member{},
+		bans:      map[string]time.Time{},
+		waiting:   map[string]*waiter{},
+		remote:    map[string]time.Time{},
+		locked:    state.Locked,
+		knock:     state.Knock,
+		limit:     state.Capacity,
+		public:    state.Public,
+		title:     state.Title,
+		tags:      state.Tags,
+		returning: map[string]bool{},
+		restoring: time.Now().Add(TIMEOUT),
+	}
+	for user, until := range state.Bans {
+		room.bans[user] = until
+	}
+	for _, user := range state.Members {
+		room.returning[user] = true
 	}
-	room.Join(Request{name, owner, preshared})
+	for user, until := range state.Approved {
+		room.waiting[user] = &waiter{state: approved, expires: until, event: make(chan *Event, 1)}
+	}
+	for user, until := range state.Denied {
+		room.waiting[user] = &waiter{state: denied, expires: until, event: make(chan *Event, 1)}
+	}
+	room.expire()
+	room.SetKinds(state.Kinds)
 	return room
 }
 
+// restarting reports whether returning members are still let in.
+func (r *Room) restarting() bool {
+	return len(r.returning) > 0 && time.Now().Before(r.restoring)
+}
+
+// State ...
+func (r *Room) State() *RoomState {
+	r.Lock()
+	defer r.Unlock()
+	state := &RoomState{
+		Name:      r.name,
+		Owner:     r.owner,
+		Preshared: r.preshared,
+		Locked:    r.locked,
+		Knock:     r.knock,
+		Capacity:  r.limit,
+		Public:    r.public,
+		Title:     r.title,
+		Tags:      r.tags,
+		Bans:      map[string]time.Time{},
+	}
+	if r.kinds != nil {
+		state.Kinds = []string{}
+		for kind := range r.kinds {
+			state.Kinds = append(state.Kinds, kind)
+		}
+	}
+	for user := range r.bans {
+		if r.banned(user) {
+			state.Bans[user] = r.bans[user]
+		}
+	}
+	for user := range r.members {
+		state.Members = append(state.Members, user)
+	}
+	if r.restarting() {
+		for user := range r.returning {
+			if r.members[user] == nil {
+				state.Members = append(state.Members, user)
+			}
+		}
+	}
+	sort.Strings(state.Members)
+	r.expire()
+	for user, w := range r.waiting {
+		switch w.state {
+		case approved:
+			if state.Approved == nil {
+				state.Approved = map[string]time.Time{}
+			}
+			state.Approved[user] = w.expires
+		case denied:
+			if state.Denied == nil {
+				state.Denied = map[string]time.Time{}
+			}
+			state.Denied[user] = w.expires
+		}
+	}
+	return state
+}
+
 // Name ...
 func (r *Room) Name() string {
 	return r.name
 }
 
-// Preshared ...
-func (r *Room) Preshared() string {
-	return r.preshared
+// Authorized reports whether req carries the room secret. Members are
+// checked against the secret they joined with, so they stay authorized
+// across SetPreshared.
+func (r *Room) Authorized(req Request) bool {
+	r.RLock()
+	m, hash := r.members[req.UserID], r.preshared
+	r.RUnlock()
+	if m != nil && m.secret != nil {
+		return hmac.Equal(m.secret, memberDigest(req.Preshared))
+	}
+	return CheckPreshared(hash, req.Preshared)
+}
+
+// SetPreshared rotates the room secret for subsequent joins.
+func (r *Room) SetPreshared(preshared string) error {
+	hash, err := HashPreshared(preshared)
+	if err != nil {
+		return err
+	}
+	r.Lock()
+	r.preshared = hash
+	r.Unlock()
+	return nil
 }
 
 // Owner ...
@@ -107,6 +341,22 @@ func (r *Room) SetCheckFunc(check func()) {
 	r.check = check
 }
 
+// SetChangeFunc sets fn to call after members join or leave.
+func (r *Room) SetChangeFunc(fn func()) {
+	r.Lock()
+	r.changed = fn
+	r.Unlock()
+}
+
+func (r *Room) change() {
+	r.RLock()
+	fn := r.changed
+	r.RUnlock()
+	if fn != nil {
+		fn()
+	}
+}
+
 // Locked ...
 func (r *Room) Locked() bool {
 	r.RLock()
@@ -121,33 +371,250 @@ func (r *Room) SetLocked(b bool) {
 	r.locked = b
 }
 
+// Capacity ...
+func (r *Room) Capacity() int {
+	r.RLock()
+	defer r.RUnlock()
+	return r.limit
+}
+
+// SetCapacity sets the maximum number of members (0 means unlimited).
+func (r *Room) SetCapacity(n int) {
+	r.Lock()
+	defer r.Unlock()
+	r.limit = n
+}
+
+// Knocking ...
+func (r *Room) Knocking() bool {
+	r.RLock()
+	defer r.RUnlock()
+	return r.knock
+}
+
+// SetKnocking enables queueing of joins on a full or locked room
+// until the owner approves or denies them.
+func (r *Room) SetKnocking(b bool) {
+	r.Lock()
+	defer r.Unlock()
+	r.knock = b
+}
+
+// Public ...
+func (r *Room) Public() bool {
+	r.RLock()
+	defer r.RUnlock()
+	return r.public
+}
+
+// SetPublic makes the room visible in room listings with title and tags.
+func (r *Room) SetPublic(b bool, title string, tags []string) {
+	r.Lock()
+	defer r.Unlock()
+	r.public = b
+	r.title = title
+	r.tags = tags
+}
+
+// SetKinds restricts the event kinds members may send (nil means any).
+func (r *Room) SetKinds(kinds []string) {
+	r.Lock()
+	defer r.Unlock()
+	if kinds == nil {
+		r.kinds = nil
+		return
+	}
+	r.kinds = map[string]bool{}
+	for _, kind := range kinds {
+		r.kinds[kind] = true
+	}
+}
+
+// Kinds ...
+func (r *Room) Kinds() []string {
+	r.RLock()
+	defer r.RUnlock()
+	if r.kinds == nil {
+		return nil
+	}
+	kinds := []string{}
+	for kind := range r.kinds {
+		kinds = append(kinds, kind)
+	}
+	return kinds
+}
+
+// AllowKind ...
+func (r *Room) AllowKind(kind string) bool {
+	r.RLock()
+	defer r.RUnlock()
+	return r.kinds == nil || r.kinds[kind]
+}
+
+// Info ...
+func (r *Room) Info() RoomInfo {
+	r.RLock()
+	defer r.RUnlock()
+	return RoomInfo{
+		RoomID:   r.name,
+		Owner:    r.owner,
+		Title:    r.title,
+		Tags:     r.tags,
+		Members:  r.count(),
+		Capacity: r.limit,
+		Locked:   r.locked,
+		Knock:    r.knock,
+	}
+}
+
+// expire drops knocks and decisions past their time.
+func (r *Room) expire() {
+	now := time.Now()
+	for user, w := range r.waiting {
+		if now.After(w.expires) {
+			delete(r.waiting, user)
+		}
+	}
+}
+
+// Waiting ...
+func (r *Room) Waiting() []string {
+	r.Lock()
+	defer r.Unlock()
+	r.expire()
+	users := []string{}
+	for user, w := range r.waiting {
+		if w.state == pending {
+			users = append(users, user)
+		}
+	}
+	return users
+}
+
+// Wait returns where the Admission of a knocking user arrives.
+func (r *Room) Wait(user string) (<-chan *Event, bool) {
+	r.Lock()
+	defer r.Unlock()
+	r.expire()
+	w, ok := r.waiting[user]
+	if !ok {
+		return nil, false
+	}
+	return w.event, true
+}
+
+// decide records the owner's decision on a knocking user for ttl and
+// tells the user.
+func (r *Room) decide(user string, state admission, ttl time.Duration) error {
+	r.Lock()
+	defer r.Unlock()
+	r.expire()
+	w, ok := r.waiting[user]
+	if !ok || w.state != pending {
+		return fmt.Errorf("not found waiting user: %s", user)
+	}
+	w.state = state
+	w.expires = time.Now().Add(ttl)
+	select {
+	case w.event <- New(r.owner, user, &Admission{Member: user, Approved: state == approved}):
+	default:
+	}
+	return nil
+}
+
+// Approve lets a knocking user join within KnockTTL.
+func (r *Room) Approve(user string) error {
+	return r.decide(user, approved, KnockTTL)
+}
+
+// Deny rejects the joins of a knocking user for DenyTTL.
+func (r *Room) Deny(user string) error {
+	return r.decide(user, denied, DenyTTL)
+}
+
+func (r *Room) admit(user string) error {
+	r.expire()
+	w, waiting := r.waiting[user]
+	switch {
+	case waiting && w.state == approved:
+		delete(r.waiting, user)
+		return nil
+	case waiting && w.state == denied:
+		return ErrDenied
+	}
+	var err error
+	switch {
+	case r.locked:
+		err = ErrLocked
+	case r.limit > 0 && r.count() >= r.limit:
+		err = ErrFull
+	default:
+		return nil
+	}
+	if !r.knock {
+		return err
+	}
+	if !waiting {
+		if len(r.waiting) >= MaxWaiting {
+			return ErrQueueFull
+		}
+		r.waiting[user] = &waiter{
+			state:   pending,
+			expires: time.Now().Add(KnockTTL),
+			event:   make(chan *Event, 1),
+		}
+		if owner := r.members[r.owner]; owner != nil {
+			owner.Push(New(user, r.owner, &Knock{Member: user}))
+		}
+	}
+	return ErrWaiting
+}
+
 // Join ...
 func (r *Room) Join(req Request) error {
-	added := false
+	added, announce := false, false
 	defer func() {
 		if added {
 			r.Send(Message{
 				Request: req,
 				Event:   New(req.UserID, "", &Leave{Member: req.UserID}),
 			})
+			r.change()
+		}
+		if announce {
+			r.publish(&Envelope{Presence: &Presence{Member: req.UserID, Joined: true}})
 		}
 	}()
 	r.Lock()
 	defer r.Unlock()
 	if m, ok := r.members[req.UserID]; ok {
 		m.Reset()
+		// other instances forget members not announced within TIMEOUT.
+		if announce = time.Since(m.announced) > TIMEOUT/3; announce {
+			m.announced = time.Now()
+		}
 		return nil
 	}
-	if r.locked {
-		return fmt.Errorf("room is locked")
+	if req.UserID != r.owner {
+		if r.banned(req.UserID) {
+			return fmt.Errorf("banned user: %s", req.UserID)
+		}
+		if !r.returning[req.UserID] || !r.restarting() {
+			if err := r.admit(req.UserID); err != nil {
+				return err
+			}
+		}
 	}
+	delete(r.returning, req.UserID)
 	m := &Member{
 		UserID: req.UserID,
 		event:  make(chan *Event, N),
+		secret: memberDigest(req.Preshared),
 	}
 	m.timer = time.AfterFunc(TIMEOUT, func() { r.Leave(req) })
+	m.announced = time.Now()
 	r.members[req.UserID] = m
-	added = true
+	added, announce = true, true
 	return nil
 }
 
@@ -155,9 +622,12 @@ func (r *Room) Join(req Request) error {
 func (r *Room) Leave(req Request) error {
 	defer func() {
 		r.RLock()
-		_, ok := r.members[r.owner]
+		ok := r.members[r.owner] != nil || r.isRemote(r.owner)
+		empty, replica := len(r.members) == 0, r.replica
+		// a restored room waits for its owner, see RestoreRoom.
+		waiting := time.Now().Before(r.restoring)
 		r.RUnlock()
-		if !ok {
+		if replica && empty || !replica && !ok && !waiting {
 			r.check()
 		}
 	}()
@@ -168,6 +638,8 @@ func (r *Room) Leave(req Request) error {
 				Request: req,
 				Event:   New(req.UserID, "", &Leave{Member: req.UserID}),
 			})
+			r.publish(&Envelope{Presence: &Presence{Member: req.UserID}})
+			r.change()
 		}
 	}()
 	r.Lock()
@@ -182,6 +654,79 @@ func (r *Room) Leave(req Request) error {
 	return nil
 }
 
+func (r *Room) banned(user string) bool {
+	until, ok := r.bans[user]
+	if !ok {
+		return false
+	}
+	if !until.IsZero() && time.Now().After(until) {
+		delete(r.bans, user)
+		return false
+	}
+	return true
+}
+
+// Banned ...
+func (r *Room) Banned(user string) bool {
+	r.Lock()
+	defer r.Unlock()
+	return r.banned(user)
+}
+
+// Kick ...
+func (r *Room) Kick(user, reason string) error {
+	return r.kick(&Kicked{Member: user, Reason: reason})
+}
+
+// Ban kicks the user and rejects further joins for d (0 means forever).
+// With a broker the ban is published even if the user is not a member
+// here, so that every instance records it.
+func (r *Room) Ban(user, reason string, d time.Duration) error {
+	if user == r.owner {
+		return fmt.Errorf("can't ban owner: %s", user)
+	}
+	ev := &Kicked{Member: user, Reason: reason, Banned: true}
+	if d > 0 {
+		ev.Until = time.Now().Add(d)
+	}
+	r.Lock()
+	r.bans[user] = ev.Until
+	broker := r.broker
+	r.Unlock()
+	if broker == nil && r.Get(user) == nil {
+		return nil
+	}
+	return r.kick(ev)
+}
+
+func (r *Room) kick(ev *Kicked) error {
+	if ev.Member == r.owner {
+		return fmt.Errorf("can't kick owner: %s", ev.Member)
+	}
+	event := New(r.owner, "", ev)
+	r.Lock()
+	delete(r.remote, ev.Member)
+	m, ok := r.members[ev.Member]
+	if !ok {
+		r.Unlock()
+		if r.broker == nil {
+			return fmt.Errorf("not found user: %s", ev.Member)
+		}
+		// may be a member on another server instance.
+		r.publish(&Envelope{Event: event})
+		return nil
+	}
+	for _, member := range r.members {
+		member.Push(event)
+	}
+	delete(r.members, ev.Member)
+	m.Close()
+	r.Unlock()
+	r.publish(&Envelope{Event: event})
+	r.change()
+	return nil
+}
+
 // Get ...
 func (r *Room) Get(user string) *Member {
 	r.RLock()
@@ -202,14 +747,18 @@ func (r *Room) Iter(fn func(m *Member)) {
 // Send ...
 func (r *Room) Send(msg Message) error {
 	r.RLock()
-	defer r.RUnlock()
 	self := r.members[msg.UserID]
 	if self == nil {
+		r.RUnlock()
 		return fmt.Errorf("you not a member: %s", msg.UserID)
 	}
 	self.Reset()
+	// never trust the sender's claim of who it is.
+	msg.Event.From = msg.UserID
+	local := false
 	if m := r.members[msg.Event.To]; msg.Event.To != "" && m != nil {
 		m.Push(msg.Event)
+		local = true
 	} else {
 		for _, m := range r.members {
 			if m != self {
@@ -217,18 +766,195 @@ func (r *Room) Send(msg Message) error {
 			}
 		}
 	}
+	r.RUnlock()
+	if !local {
+		r.publish(&Envelope{Event: msg.Event})
+	}
 	return nil
 }
 
+// Attach shares the room's events with other server instances through
+// broker. A replica is a local copy of a room created on another instance,
+// the members of the state it was restored from are on other instances.
+// Members are announced to the other instances, so that counts and
+// capacity span them; admission stays local to each instance.
+func (r *Room) Attach(broker Broker, origin string, replica bool) error {
+	unsub, err := broker.Subscribe(r.name, r.receive)
+	if err != nil {
+		return err
+	}
+	r.Lock()
+	r.broker = broker
+	r.origin = origin
+	r.replica = replica
+	r.unsub = unsub
+	if replica {
+		until := time.Now().Add(TIMEOUT)
+		for user := range r.returning {
+			r.remote[user] = until
+		}
+		r.returning = map[string]bool{}
+		r.restoring = time.Time{}
+	}
+	r.Unlock()
+	return nil
+}
+
+// isRemote reports whether user is a member on another instance.
+func (r *Room) isRemote(user string) bool {
+	until, ok := r.remote[user]
+	return ok && time.Now().Before(until)
+}
+
+// count is the number of members on all instances.
+func (r *Room) count() int {
+	n := len(r.members)
+	for user := range r.remote {
+		if r.members[user] == nil && r.isRemote(user) {
+			n++
+		}
+	}
+	return n
+}
+
+// MemberIDs returns the members on all instances.
+func (r *Room) MemberIDs() []string {
+	r.RLock()
+	defer r.RUnlock()
+	users := []string{}
+	for user := range r.members {
+		users = append(users, user)
+	}
+	for user := range r.remote {
+		if r.members[user] == nil && r.isRemote(user) {
+			users = append(users, user)
+		}
+	}
+	sort.Strings(users)
+	return users
+}
+
+// Empty reports whether the room has no members on this instance.
+func (r *Room) Empty() bool {
+	r.RLock()
+	defer r.RUnlock()
+	return len(r.members) == 0
+}
+
+// Replica ...
+func (r *Room) Replica() bool {
+	r.RLock()
+	defer r.RUnlock()
+	return r.replica
+}
+
+func (r *Room) publish(env *Envelope) {
+	r.RLock()
+	broker, origin := r.broker, r.origin
+	r.RUnlock()
+	if broker == nil {
+		return
+	}
+	env.Origin = origin
+	if err := broker.Publish(r.name, env); err != nil {
+		log.Println("publish failed:", r.name, err)
+	}
+}
+
+func (r *Room) receive(env *Envelope) {
+	r.RLock()
+	origin, replica := r.origin, r.replica
+	r.RUnlock()
+	if env.Origin == origin {
+		return
+	}
+	switch {
+	case env.Lookup:
+		if !replica {
+			state := r.State()
+			r.RLock()
+			for user := range r.remote {
+				if r.isRemote(user) {
+					state.Members = append(state.Members, user)
+				}
+			}
+			r.RUnlock()
+			r.publish(&Envelope{State: state})
+		}
+	case env.Presence != nil:
+		r.Lock()
+		if env.Presence.Joined {
+			r.remote[env.Presence.Member] = time.Now().Add(TIMEOUT)
+		} else {
+			delete(r.remote, env.Presence.Member)
+		}
+		r.Unlock()
+	case env.Closed:
+		if replica {
+			r.check()
+		}
+	case env.Event != nil:
+		r.deliver(env.Event)
+	}
+}
+
+func (r *Room) deliver(ev *Event) {
+	// only kicks are looked into, members' events may be sealed.
+	if ev.Kind == (&Kicked{}).Kind() {
+		if k, ok := ev.Get().(*Kicked); ok {
+			r.Lock()
+			if k.Banned {
+				r.bans[k.Member] = k.Until
+			}
+			delete(r.remote, k.Member)
+			m, ok := r.members[k.Member]
+			if ok {
+				m.Push(ev)
+				delete(r.members, k.Member)
+				m.Close()
+			}
+			empty, replica := len(r.members) == 0, r.replica
+			r.Unlock()
+			if ok {
+				r.change()
+				// a replica is kept for its members only.
+				if empty && replica {
+					r.check()
+					return
+				}
+			}
+		}
+	}
+	r.RLock()
+	defer r.RUnlock()
+	if m := r.members[ev.To]; ev.To != "" {
+		if m != nil {
+			m.Push(ev)
+		}
+		return
+	}
+	for _, m := range r.members {
+		m.Push(ev)
+	}
+}
+
 // Close ...
 func (r *Room) Close() {
 	r.Lock()
-	defer r.Unlock()
 	members := r.members
 	r.members = map[string]*Member{}
 	for _, m := range members {
 		m.Close()
 	}
+	unsub, replica := r.unsub, r.replica
+	r.unsub = nil
+	r.Unlock()
+	if unsub != nil {
+		if !replica {
+			r.publish(&Envelope{Closed: true})
+		}
+		unsub()
+	}
 }
 
 // Request ...
@@ -236,6 +962,30 @@ type Request struct {
 	RoomID    string
 	UserID    string
 	Preshared string
+	Token     string `json:",omitempty"` // signed token (JWT) when the server requires one
+	Proof     *Proof `json:",omitempty"` // answer to Signaling.Challenge for key derived UserID
+}
+
+// Identity ...
+func (r *Request) Identity() (room, user string) {
+	return r.RoomID, r.UserID
+}
+
+// KeyProof ...
+func (r *Request) KeyProof() *Proof {
+	return r.Proof
+}
+
+// AuthToken ...
+func (r *Request) AuthToken() string {
+	return r.Token
+}
+
+// SetToken sets token unless the request carries its own.
+func (r *Request) SetToken(token string) {
+	if len(r.Token) == 0 {
+		r.Token = token
+	}
 }
 
 // Valid ...
@@ -257,6 +1007,39 @@ type Message struct {
 
 // Members ...
 type Members struct {
-	Owner  string
-	Member []string
+	Owner   string
+	Member  []string
+	Waiting []string
+}
+
+// RoomInfo ...
+type RoomInfo struct {
+	RoomID   string
+	Owner    string
+	Title    string
+	Tags     []string
+	Members  int
+	Capacity int
+	Locked   bool
+	Knock    bool
+}
+
+// Joinable reports whether a join would be accepted without knocking.
+func (i RoomInfo) Joinable() bool {
+	return !i.Locked && (i.Capacity == 0 || i.Members < i.Capacity)
+}
+
+// IceServer is an entry of RTCConfiguration.iceServers. Credentials
+// stop working after Expires unless it is zero.
+type IceServer struct {
+	URLs       []string
+	Username   string `json:",omitempty"`
+	Credential string `json:",omitempty"`
+	Expires    time.Time
+}
+
+// RoomList ...
+type RoomList struct {
+	Rooms []RoomInfo
+	Total int
 }
@@ -0,0 +1,192 @@
+package signaling
+
+import (
+	"testing"
+	"time"
+)
+
+func init() { Verbose = false }
+
+func newTestRoom(t *testing.T) *Room {
+	t.Helper()
+	room, err := NewRoom("room", "owner", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.SetCheckFunc(func() {})
+	t.Cleanup(room.Close)
+	return room
+}
+
+func join(room *Room, user string) error {
+	return room.Join(Request{RoomID: room.Name(), UserID: user, Preshared: "secret"})
+}
+
+// drain returns the events queued for m.
+func drain(m *Member) []*Event {
+	events := []*Event{}
+	for {
+		select {
+		case ev, ok := <-m.Pop():
+			if !ok {
+				return events
+			}
+			events = append(events, ev)
+		default:
+			return events
+		}
+	}
+}
+
+func TestKickBan(t *testing.T) {
+	tests := []struct {
+		name    string
+		act     func(r *Room) error
+		fail    bool // act fails
+		rejoin  bool // bob may join again afterwards
+		kickEvt bool // bob got a Kicked event
+	}{
+		{"kick", func(r *Room) error { return r.Kick("bob", "") }, false, true, true},
+		{"ban", func(r *Room) error { return r.Ban("bob", "", 0) }, false, false, true},
+		{"ban expired", func(r *Room) error { return r.Ban("bob", "", time.Nanosecond) }, false, true, true},
+		{"kick owner", func(r *Room) error { return r.Kick("owner", "") }, true, true, false},
+		{"ban owner", func(r *Room) error { return r.Ban("owner", "", 0) }, true, true, false},
+		{"kick stranger", func(r *Room) error { return r.Kick("eve", "") }, true, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			room := newTestRoom(t)
+			if err := join(room, "bob"); err != nil {
+				t.Fatal(err)
+			}
+			bob := room.Get("bob")
+			if err := tt.act(room); (err != nil) != tt.fail {
+				t.Fatalf("got %v, want failure %v", err, tt.fail)
+			}
+			got := false
+			for _, ev := range drain(bob) {
+				if _, ok := ev.Get().(*Kicked); ok {
+					got = true
+				}
+			}
+			if got != tt.kickEvt {
+				t.Errorf("kicked event %v, want %v", got, tt.kickEvt)
+			}
+			time.Sleep(time.Millisecond)
+			if err := join(room, "bob"); (err == nil) != tt.rejoin {
+				t.Errorf("rejoin: %v, want allowed %v", err, tt.rejoin)
+			}
+		})
+	}
+}
+
+func TestAdmission(t *testing.T) {
+	tests := []struct {
+		name   string
+		decide func(r *Room) error
+		want   []error // of the joins after the decision
+		admit  *bool   // the Admission event bob gets, nil for none
+	}{
+		{"pending", nil, []error{ErrWaiting, ErrWaiting}, nil},
+		{"approve", func(r *Room) error { return r.Approve("bob") }, []error{nil}, newBool(true)},
+		{"deny", func(r *Room) error { return r.Deny("bob") }, []error{ErrDenied, ErrDenied}, newBool(false)},
+		{"approve twice", func(r *Room) error {
+			r.Approve("bob")
+			return r.Approve("bob")
+		}, []error{nil}, newBool(true)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			room := newTestRoom(t)
+			room.SetLocked(true)
+			room.SetKnocking(true)
+			if err := join(room, "bob"); err != ErrWaiting {
+				t.Fatalf("knock: %v", err)
+			}
+			if w := room.Waiting(); len(w) != 1 || w[0] != "bob" {
+				t.Fatalf("waiting: %v", w)
+			}
+			knocks := 0
+			for _, ev := range drain(room.Get("owner")) {
+				if _, ok := ev.Get().(*Knock); ok {
+					knocks++
+				}
+			}
+			if knocks != 1 {
+				t.Errorf("owner got %d knocks", knocks)
+			}
+			decision, _ := room.Wait("bob")
+			if tt.decide != nil {
+				err := tt.decide(room)
+				if (err != nil) != (tt.name == "approve twice") {
+					t.Errorf("decide: %v", err)
+				}
+			}
+			select {
+			case ev := <-decision:
+				a, ok := ev.Get().(*Admission)
+				if !ok || tt.admit == nil || a.Approved != *tt.admit {
+					t.Errorf("admission %+v, want %v", ev.Get(), tt.admit)
+				}
+			default:
+				if tt.admit != nil {
+					t.Errorf("no admission event")
+				}
+			}
+			for i, want := range tt.want {
+				if err := join(room, "bob"); err != want {
+					t.Errorf("join %d: %v, want %v", i, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAdmissionLimits(t *testing.T) {
+	defer func(max int, knock, deny time.Duration) {
+		MaxWaiting, KnockTTL, DenyTTL = max, knock, deny
+	}(MaxWaiting, KnockTTL, DenyTTL)
+	MaxWaiting, KnockTTL, DenyTTL = 2, time.Hour, 10*time.Millisecond
+
+	room := newTestRoom(t)
+	room.SetLocked(true)
+	room.SetKnocking(true)
+	tests := []struct {
+		user string
+		want error
+	}{
+		{"a", ErrWaiting},
+		{"b", ErrWaiting},
+		{"c", ErrQueueFull},
+		{"a", ErrWaiting},
+	}
+	for _, tt := range tests {
+		if err := join(room, tt.user); err != tt.want {
+			t.Errorf("%s: %v, want %v", tt.user, err, tt.want)
+		}
+	}
+	if err := room.Deny("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := join(room, "a"); err != ErrDenied {
+		t.Errorf("denied: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	// the denial expired, so a knocks again.
+	if err := join(room, "a"); err != ErrWaiting {
+		t.Errorf("after DenyTTL: %v", err)
+	}
+	KnockTTL = 10 * time.Millisecond
+	room = newTestRoom(t)
+	room.SetLocked(true)
+	room.SetKnocking(true)
+	if err := join(room, "c"); err != ErrWaiting {
+		t.Errorf("c: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if w := room.Waiting(); len(w) != 0 {
+		t.Errorf("expired knocks stay: %v", w)
+	}
+}
+
+func newBool(b bool) *bool { return &b }
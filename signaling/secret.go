@@ -0,0 +1,46 @@
+package signaling
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// memberKey keys the in-memory digests of the secret each member joined
+// with, so requests of members skip bcrypt. It never leaves the process.
+var memberKey = func() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}()
+
+// prehash lifts the 72 bytes input limit of bcrypt.
+func prehash(preshared string) []byte {
+	sum := sha256.Sum256([]byte(preshared))
+	return []byte(base64.StdEncoding.EncodeToString(sum[:]))
+}
+
+// HashPreshared returns a salted hash of preshared for storing.
+func HashPreshared(preshared string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword(prehash(preshared), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPreshared compares preshared with hash in constant time.
+func CheckPreshared(hash, preshared string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), prehash(preshared)) == nil
+}
+
+func memberDigest(preshared string) []byte {
+	mac := hmac.New(sha256.New, memberKey)
+	mac.Write([]byte(preshared))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,60 @@
+package signaling
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPreshared(t *testing.T) {
+	long := strings.Repeat("x", 80)
+	tests := []struct {
+		name      string
+		preshared string
+		try       string
+		want      bool
+	}{
+		{"match", "secret", "secret", true},
+		{"mismatch", "secret", "Secret", false},
+		{"empty", "", "", true},
+		{"empty try", "secret", "", false},
+		{"past 72 bytes", long + "a", long + "b", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, err := HashPreshared(tt.preshared)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(hash, tt.preshared) && len(tt.preshared) > 0 {
+				t.Errorf("hash holds the secret")
+			}
+			if got := CheckPreshared(hash, tt.try); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizedMember(t *testing.T) {
+	room := newTestRoom(t)
+	if err := join(room, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := room.SetPreshared("rotated"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		user, preshared string
+		want            bool
+	}{
+		{"bob", "secret", true}, // joined before the rotation
+		{"bob", "rotated", false},
+		{"carol", "rotated", true},
+		{"carol", "secret", false},
+	}
+	for _, tt := range tests {
+		if got := room.Authorized(Request{RoomID: "room", UserID: tt.user, Preshared: tt.preshared}); got != tt.want {
+			t.Errorf("%s with %q: %v, want %v", tt.user, tt.preshared, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/rpc"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nobonobo/p2pfw/signaling"
+)
+
+// Claims of a signaling token.
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+	// Rooms and Create restrict the RoomIDs (path.Match patterns)
+	// the token may join and create. nil means any room.
+	Rooms  []string `json:"rooms"`
+	Create []string `json:"create"`
+}
+
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+func matchRoom(patterns []string, room string) bool {
+	if patterns == nil {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, room); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator verifies HMAC (HS*) and RSA/ECDSA (RS*, ES*) JWTs.
+type Authenticator struct {
+	secret   []byte
+	keys     []crypto.PublicKey
+	required bool
+	issuer   string
+	audience string
+}
+
+// NewAuthenticator ...
+func NewAuthenticator(c *Config) (*Authenticator, error) {
+	a := &Authenticator{
+		secret:   []byte(c.AuthSecret),
+		required: c.AuthRequired,
+		issuer:   c.AuthIssuer,
+		audience: c.AuthAudience,
+	}
+	for _, name := range c.AuthKeys {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			var block *pem.Block
+			block, b = pem.Decode(b)
+			if block == nil {
+				break
+			}
+			var key interface{}
+			switch block.Type {
+			case "PUBLIC KEY":
+				key, err = x509.ParsePKIXPublicKey(block.Bytes)
+			case "RSA PUBLIC KEY":
+				key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+			case "CERTIFICATE":
+				var cert *x509.Certificate
+				if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+					key = cert.PublicKey
+				}
+			default:
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+			switch key.(type) {
+			case *rsa.PublicKey, *ecdsa.PublicKey:
+				a.keys = append(a.keys, key)
+			default:
+				return nil, fmt.Errorf("%s: unsupported key type %T", name, key)
+			}
+		}
+	}
+	if len(c.AuthKeys) > 0 && len(a.keys) == 0 {
+		return nil, fmt.Errorf("no public key found: %v", c.AuthKeys)
+	}
+	return a, nil
+}
+
+func hashFor(alg string) (crypto.Hash, func() hash.Hash) {
+	switch alg[2:] {
+	case "256":
+		return crypto.SHA256, sha256.New
+	case "384":
+		return crypto.SHA384, sha512.New384
+	case "512":
+		return crypto.SHA512, sha512.New
+	}
+	return 0, nil
+}
+
+// Verify checks signature and time claims of token.
+func (a *Authenticator) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token header")
+	}
+	if err := json.Unmarshal(b, &header); err != nil {
+		return nil, fmt.Errorf("malformed token header")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature")
+	}
+	if len(header.Alg) != 5 {
+		return nil, fmt.Errorf("unsupported token alg: %q", header.Alg)
+	}
+	h, newHash := hashFor(header.Alg)
+	if newHash == nil {
+		return nil, fmt.Errorf("unsupported token alg: %q", header.Alg)
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	if !a.verifySignature(header.Alg[:2], h, newHash, signed, sig) {
+		return nil, fmt.Errorf("invalid token signature")
+	}
+	b, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token claims")
+	}
+	claims := new(Claims)
+	if err := json.Unmarshal(b, claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims")
+	}
+	now := time.Now().Unix()
+	if claims.ExpiresAt != 0 && now >= claims.ExpiresAt {
+		return nil, fmt.Errorf("token expired")
+	}
+	if claims.NotBefore != 0 && now < claims.NotBefore {
+		return nil, fmt.Errorf("token not valid yet")
+	}
+	if len(a.issuer) > 0 && claims.Issuer != a.issuer {
+		return nil, fmt.Errorf("token issuer mismatch")
+	}
+	if len(a.audience) > 0 {
+		found := false
+		for _, aud := range claims.Audience {
+			found = found || aud == a.audience
+		}
+		if !found {
+			return nil, fmt.Errorf("token audience mismatch")
+		}
+	}
+	if len(claims.Subject) == 0 {
+		return nil, fmt.Errorf("token has no subject")
+	}
+	return claims, nil
+}
+
+func (a *Authenticator) verifySignature(family string, h crypto.Hash, newHash func() hash.Hash, signed, sig []byte) bool {
+	switch family {
+	case "HS":
+		if len(a.secret) == 0 {
+			return false
+		}
+		mac := hmac.New(newHash, a.secret)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), sig)
+	case "RS":
+		d := newHash()
+		d.Write(signed)
+		digest := d.Sum(nil)
+		for _, key := range a.keys {
+			if k, ok := key.(*rsa.PublicKey); ok && rsa.VerifyPKCS1v15(k, h, digest, sig) == nil {
+				return true
+			}
+		}
+	case "ES":
+		d := newHash()
+		d.Write(signed)
+		digest := d.Sum(nil)
+		for _, key := range a.keys {
+			k, ok := key.(*ecdsa.PublicKey)
+			if !ok {
+				continue
+			}
+			size := (k.Curve.Params().BitSize + 7) / 8
+			if len(sig) != 2*size {
+				continue
+			}
+			r := new(big.Int).SetBytes(sig[:size])
+			s := new(big.Int).SetBytes(sig[size:])
+			if ecdsa.Verify(k, digest, r, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Authorize checks the token of req against its UserID and RoomID.
+func (a *Authenticator) Authorize(req *signaling.Request, create bool) error {
+	if len(req.Token) == 0 {
+		if a.required {
+			return fmt.Errorf("token required")
+		}
+		return nil
+	}
+	claims, err := a.Verify(req.Token)
+	if err != nil {
+		return err
+	}
+	if claims.Subject != req.UserID {
+		return fmt.Errorf("token subject mismatch: %s", req.UserID)
+	}
+	if create {
+		if !matchRoom(claims.Create, req.RoomID) {
+			return fmt.Errorf("token not allowed to create room: %s", req.RoomID)
+		}
+		return nil
+	}
+	// rooms a token may create are also joinable by it.
+	if !matchRoom(claims.Rooms, req.RoomID) &&
+		!(claims.Create != nil && matchRoom(claims.Create, req.RoomID)) {
+		return fmt.Errorf("token not allowed to join room: %s", req.RoomID)
+	}
+	return nil
+}
+
+// handshakeToken returns the bearer token given on a WebSocket handshake
+// or a JSON-RPC over POST request.
+func handshakeToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(auth[len("Bearer "):])
+	}
+	return r.URL.Query().Get("token")
+}
+
+type tokenSetter interface {
+	SetToken(token string)
+}
+
+// withToken fills the handshake token into requests without one.
+type withToken struct {
+	rpc.ServerCodec
+	token string
+}
+
+// ReadRequestBody ...
+func (c *withToken) ReadRequestBody(x interface{}) error {
+	if err := c.ServerCodec.ReadRequestBody(x); err != nil {
+		return err
+	}
+	if t, ok := x.(tokenSetter); ok {
+		t.SetToken(c.token)
+	}
+	return nil
+}
+
+type identifier interface {
+	Identity() (room, user string)
+}
+
+type prover interface {
+	KeyProof() *signaling.Proof
+}
+
+type tokenGetter interface {
+	AuthToken() string
+}
+
+type identity struct {
+	room string
+	user string
+}
+
+type binding struct {
+	identity
+	release bool
+}
+
+// bindIdentity binds a connection to the (RoomID, UserID) of its first
+// Join or CreateRoom as soon as the request is read, and rejects requests
+// for other identities until a successful Leave or DestroyRoom. A failed
+// Join unbinds again, unless it waits for approval so that the knocking
+// user may Pull. Until bound a key derived UserID must come with the
+// answer to a challenge, and only Join, CreateRoom, Challenge and
+// anonymous requests (Signaling.IceServers without RoomID and UserID) are
+// served.
+//
+// A JSON-RPC over POST request is a connection of its own, so with auth
+// set its other requests are served when they carry a token for their
+// identity, or the answer to a challenge for a key derived UserID.
+type bindIdentity struct {
+	rpc.ServerCodec
+	method  string
+	seq     uint64
+	mu      sync.Mutex
+	pending map[uint64]binding
+	bound   *identity
+	proofs  *challenges
+	auth    *Authenticator // stateless, for JSON-RPC over POST
+}
+
+// opens reports whether method may be called on an unbound connection.
+func opens(method string) bool {
+	switch method {
+	case "Signaling.Join", "Signaling.CreateRoom", "Signaling.Challenge":
+		return true
+	}
+	return false
+}
+
+// authenticate checks that a request of an unbound stateless connection
+// carries a token or a key proof for id.
+func (c *bindIdentity) authenticate(x interface{}, id identity) error {
+	if t, ok := x.(tokenGetter); ok && len(t.AuthToken()) > 0 {
+		req := &signaling.Request{RoomID: id.room, UserID: id.user, Token: t.AuthToken()}
+		return c.auth.Authorize(req, false)
+	}
+	if _, ok := signaling.UserKey(id.user); ok {
+		return nil // checked as any unbound request
+	}
+	return fmt.Errorf("%s needs a token or a key derived UserID over http", c.method)
+}
+
+// ReadRequestHeader ...
+func (c *bindIdentity) ReadRequestHeader(r *rpc.Request) error {
+	err := c.ServerCodec.ReadRequestHeader(r)
+	if err == nil {
+		c.method, c.seq = r.ServiceMethod, r.Seq
+	}
+	return err
+}
+
+// ReadRequestBody ...
+func (c *bindIdentity) ReadRequestBody(x interface{}) error {
+	if err := c.ServerCodec.ReadRequestBody(x); err != nil {
+		return err
+	}
+	req, ok := x.(identifier)
+	if !ok {
+		return nil
+	}
+	room, user := req.Identity()
+	if len(room) == 0 && len(user) == 0 && c.method == "Signaling.IceServers" {
+		return nil
+	}
+	id := identity{room, user}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.bound != nil && *c.bound != id {
+		return fmt.Errorf("connection bound to %s in %s", c.bound.user, c.bound.room)
+	}
+	if c.bound == nil && !opens(c.method) {
+		if c.auth == nil {
+			return fmt.Errorf("%s before Join", c.method)
+		}
+		if err := c.authenticate(x, id); err != nil {
+			return err
+		}
+	}
+	if c.bound == nil && c.method != "Signaling.Challenge" {
+		var proof *signaling.Proof
+		if p, ok := x.(prover); ok {
+			proof = p.KeyProof()
+		}
+		if err := c.proofs.check(id, proof); err != nil {
+			return err
+		}
+	}
+	switch c.method {
+	case "Signaling.Join", "Signaling.CreateRoom":
+		if c.bound == nil {
+			c.bound = &id
+			c.pending[c.seq] = binding{id, false}
+		}
+	case "Signaling.Leave", "Signaling.DestroyRoom":
+		if c.bound != nil {
+			c.pending[c.seq] = binding{id, true}
+		}
+	}
+	return nil
+}
+
+// WriteResponse ...
+func (c *bindIdentity) WriteResponse(r *rpc.Response, x interface{}) error {
+	c.mu.Lock()
+	if b, ok := c.pending[r.Seq]; ok && c.bound != nil && *c.bound == b.identity {
+		failed := len(r.Error) > 0
+		switch {
+		case b.release && !failed:
+			c.bound = nil
+		case !b.release && failed && r.Error != signaling.ErrWaiting.Error():
+			c.bound = nil
+		}
+	}
+	delete(c.pending, r.Seq)
+	c.mu.Unlock()
+	return c.ServerCodec.WriteResponse(r, x)
+}
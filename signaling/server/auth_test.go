@@ -0,0 +1,322 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/rpc"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nobonobo/p2pfw/signaling"
+)
+
+// sign makes a JWT of claims with alg HS256, RS256 or ES256.
+func sign(t *testing.T, alg string, key interface{}, claims interface{}) string {
+	t.Helper()
+	enc := base64.RawURLEncoding
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := enc.EncodeToString(header) + "." + enc.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return signed + "." + enc.EncodeToString(sig)
+}
+
+func writePublicKey(t *testing.T, dir, name string, key crypto.PublicKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherEC, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	secret := []byte("secret")
+	config := DefaultConfig()
+	config.AuthSecret = string(secret)
+	config.AuthIssuer = "issuer"
+	config.AuthAudience = "signaling"
+	config.AuthKeys = []string{
+		writePublicKey(t, dir, "rsa.pem", &rsaKey.PublicKey),
+		writePublicKey(t, dir, "ec.pem", &ecKey.PublicKey),
+	}
+	a, err := NewAuthenticator(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Unix()
+	claims := func(edit func(c map[string]interface{})) map[string]interface{} {
+		c := map[string]interface{}{"sub": "alice", "iss": "issuer", "aud": "signaling", "exp": now + 60}
+		if edit != nil {
+			edit(c)
+		}
+		return c
+	}
+	tests := []struct {
+		name  string
+		token string
+		fail  bool
+	}{
+		{"HS256", sign(t, "HS256", secret, claims(nil)), false},
+		{"RS256", sign(t, "RS256", rsaKey, claims(nil)), false},
+		{"ES256", sign(t, "ES256", ecKey, claims(nil)), false},
+		{"audience list", sign(t, "HS256", secret, claims(func(c map[string]interface{}) {
+			c["aud"] = []string{"other", "signaling"}
+		})), false},
+		{"wrong secret", sign(t, "HS256", []byte("guess"), claims(nil)), true},
+		{"unknown key", sign(t, "ES256", otherEC, claims(nil)), true},
+		{"alg none", sign(t, "none", secret, claims(nil)), true},
+		{"alg confusion", sign(t, "RS256", secret, claims(nil)), true},
+		{"expired", sign(t, "HS256", secret, claims(func(c map[string]interface{}) { c["exp"] = now - 1 })), true},
+		{"not yet", sign(t, "HS256", secret, claims(func(c map[string]interface{}) { c["nbf"] = now + 60 })), true},
+		{"issuer", sign(t, "HS256", secret, claims(func(c map[string]interface{}) { c["iss"] = "else" })), true},
+		{"audience", sign(t, "HS256", secret, claims(func(c map[string]interface{}) { c["aud"] = "else" })), true},
+		{"no subject", sign(t, "HS256", secret, claims(func(c map[string]interface{}) { delete(c, "sub") })), true},
+		{"malformed", "a.b", true},
+		{"bad base64", "!!.e30.e30", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := a.Verify(tt.token)
+			if (err != nil) != tt.fail {
+				t.Errorf("got %v, want failure %v", err, tt.fail)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	secret := []byte("secret")
+	config := DefaultConfig()
+	config.AuthSecret = string(secret)
+	config.AuthRequired = true
+	a, err := NewAuthenticator(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := sign(t, "HS256", secret, map[string]interface{}{
+		"sub": "alice", "rooms": []string{"team-*"}, "create": []string{"alice-*"},
+	})
+	tests := []struct {
+		name   string
+		req    signaling.Request
+		create bool
+		fail   bool
+	}{
+		{"join", signaling.Request{RoomID: "team-a", UserID: "alice", Token: token}, false, false},
+		{"join created", signaling.Request{RoomID: "alice-1", UserID: "alice", Token: token}, false, false},
+		{"join other", signaling.Request{RoomID: "other", UserID: "alice", Token: token}, false, true},
+		{"create", signaling.Request{RoomID: "alice-1", UserID: "alice", Token: token}, true, false},
+		{"create joinable", signaling.Request{RoomID: "team-a", UserID: "alice", Token: token}, true, true},
+		{"subject", signaling.Request{RoomID: "team-a", UserID: "bob", Token: token}, false, true},
+		{"required", signaling.Request{RoomID: "team-a", UserID: "alice"}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := a.Authorize(&tt.req, tt.create); (err != nil) != tt.fail {
+				t.Errorf("got %v, want failure %v", err, tt.fail)
+			}
+		})
+	}
+}
+
+// bodyCodec reads x from a fixed body.
+type bodyCodec struct {
+	fakeCodec
+	body interface{}
+}
+
+func (c *bodyCodec) ReadRequestBody(x interface{}) error {
+	b, _ := json.Marshal(c.body)
+	return json.Unmarshal(b, x)
+}
+
+func TestWithToken(t *testing.T) {
+	tests := []struct {
+		name string
+		body interface{}
+		x    tokenSetter
+		want string
+	}{
+		{"request", signaling.Request{RoomID: "r", UserID: "u"}, new(signaling.Request), "handshake"},
+		{"request own token", signaling.Request{Token: "own"}, new(signaling.Request), "own"},
+		{"list rooms", ListRooms{}, new(ListRooms), "handshake"},
+		{"list rooms own token", ListRooms{Token: "own"}, new(ListRooms), "own"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c rpc.ServerCodec = &withToken{&bodyCodec{body: tt.body}, "handshake"}
+			if err := c.ReadRequestBody(tt.x); err != nil {
+				t.Fatal(err)
+			}
+			var got string
+			switch x := tt.x.(type) {
+			case *signaling.Request:
+				got = x.Token
+			case *ListRooms:
+				got = x.Token
+			}
+			if got != tt.want {
+				t.Errorf("token %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBindIdentity(t *testing.T) {
+	type step struct {
+		method   string
+		user     string
+		reply    error // response of the handler
+		rejected bool  // by bindIdentity
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"bound at read", []step{
+			{"Signaling.Join", "alice", nil, false},
+			{"Signaling.Send", "bob", nil, true},
+			{"Signaling.Send", "alice", nil, false},
+		}},
+		{"unbound on failure", []step{
+			{"Signaling.Join", "alice", errors.New("room not found"), false},
+			{"Signaling.Join", "bob", nil, false},
+			{"Signaling.Send", "alice", nil, true},
+		}},
+		{"kept while waiting", []step{
+			{"Signaling.Join", "alice", signaling.ErrWaiting, false},
+			{"Signaling.Pull", "alice", nil, false},
+			{"Signaling.Join", "bob", nil, true},
+		}},
+		{"released by leave", []step{
+			{"Signaling.CreateRoom", "alice", nil, false},
+			{"Signaling.Leave", "alice", nil, false},
+			{"Signaling.Join", "bob", nil, false},
+		}},
+		{"join first", []step{
+			{"Signaling.Send", "alice", nil, true},
+			{"Signaling.Members", "alice", nil, true},
+			{"Signaling.Kick", "alice", nil, true},
+			{"Signaling.Challenge", "alice", nil, false},
+			{"Signaling.Join", "alice", nil, false},
+			{"Signaling.Members", "alice", nil, false},
+		}},
+		{"anonymous ice servers", []step{
+			{"Signaling.Join", "alice", nil, false},
+			{"Signaling.IceServers", "", nil, false},
+			{"Signaling.Send", "", nil, true},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := &bodyCodec{}
+			c := &bindIdentity{ServerCodec: body, pending: map[uint64]binding{}, proofs: new(challenges)}
+			for i, st := range tt.steps {
+				body.method = st.method
+				body.body = signaling.Request{UserID: st.user}
+				if len(st.user) > 0 {
+					body.body = signaling.Request{RoomID: "room", UserID: st.user}
+				}
+				var r rpc.Request
+				if err := c.ReadRequestHeader(&r); err != nil {
+					t.Fatal(err)
+				}
+				err := c.ReadRequestBody(new(signaling.Request))
+				if rejected := err != nil; rejected != st.rejected {
+					t.Fatalf("%d %s %s: %v, want rejected %v", i, st.method, st.user, err, st.rejected)
+				}
+				resp := &rpc.Response{Seq: r.Seq}
+				switch {
+				case err != nil:
+					resp.Error = err.Error()
+				case st.reply != nil:
+					resp.Error = st.reply.Error()
+				}
+				if err := c.WriteResponse(resp, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestBindIdentityHTTP(t *testing.T) {
+	secret := []byte("secret")
+	config := DefaultConfig()
+	config.AuthSecret = string(secret)
+	a, err := NewAuthenticator(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := sign(t, "HS256", secret, map[string]interface{}{"sub": "alice"})
+	tests := []struct {
+		name     string
+		method   string
+		req      signaling.Request
+		rejected bool
+	}{
+		{"join", "Signaling.Join", signaling.Request{RoomID: "room", UserID: "alice"}, false},
+		{"send", "Signaling.Send", signaling.Request{RoomID: "room", UserID: "alice"}, true},
+		{"send with token", "Signaling.Send", signaling.Request{RoomID: "room", UserID: "alice", Token: alice}, false},
+		{"send with other token", "Signaling.Send", signaling.Request{RoomID: "room", UserID: "bob", Token: alice}, true},
+		{"send with bad token", "Signaling.Pull", signaling.Request{RoomID: "room", UserID: "alice", Token: "x.y.z"}, true},
+		{"anonymous ice servers", "Signaling.IceServers", signaling.Request{}, false},
+	}
+	for _, tt := range tests {
+		body := &bodyCodec{body: tt.req}
+		body.method = tt.method
+		c := &bindIdentity{ServerCodec: body, pending: map[uint64]binding{}, proofs: new(challenges), auth: a}
+		var r rpc.Request
+		if err := c.ReadRequestHeader(&r); err != nil {
+			t.Fatal(err)
+		}
+		err := c.ReadRequestBody(new(signaling.Request))
+		if rejected := err != nil; rejected != tt.rejected {
+			t.Errorf("%s: %v, want rejected %v", tt.name, err, tt.rejected)
+		}
+	}
+}
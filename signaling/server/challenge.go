@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nobonobo/p2pfw/signaling"
+)
+
+type challenge struct {
+	identity
+	expires time.Time
+}
+
+// challenges are the nonces handed out by Signaling.Challenge, each of
+// them may answer one request before it expires.
+type challenges struct {
+	mu sync.Mutex
+	m  map[string]challenge
+}
+
+func (c *challenges) issue(id identity) (*signaling.Challenge, error) {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	ch := challenge{id, now.Add(signaling.ChallengeTTL)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.m == nil {
+		c.m = map[string]challenge{}
+	}
+	for k, v := range c.m {
+		if now.After(v.expires) {
+			delete(c.m, k)
+		}
+	}
+	c.m[string(nonce)] = ch
+	return &signaling.Challenge{Nonce: nonce, Expires: ch.expires}, nil
+}
+
+// check verifies that proof answers a challenge issued to id with the key
+// id.user is derived from. Other user ids need no proof.
+func (c *challenges) check(id identity, proof *signaling.Proof) error {
+	key, ok := signaling.UserKey(id.user)
+	if !ok {
+		return nil
+	}
+	if proof == nil {
+		return fmt.Errorf("must prove the key of %s, see Signaling.Challenge", id.user)
+	}
+	c.mu.Lock()
+	ch, ok := c.m[string(proof.Nonce)]
+	delete(c.m, string(proof.Nonce))
+	c.mu.Unlock()
+	if !ok || ch.identity != id || time.Now().After(ch.expires) {
+		return fmt.Errorf("unknown or expired challenge")
+	}
+	if !ed25519.Verify(key, signaling.ProofMessage(id.room, id.user, proof.Nonce), proof.Sig) {
+		return fmt.Errorf("key proof mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/nobonobo/p2pfw/signaling"
+)
+
+func TestChallenges(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := signaling.KeyUserID(key.Public().(ed25519.PublicKey))
+	var c challenges
+	// prove issues a challenge to issued and answers it for req with k.
+	prove := func(issued identity, req signaling.Request, k ed25519.PrivateKey) *signaling.Proof {
+		ch, err := c.issue(issued)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signaling.Prove(&req, k, ch)
+		return req.Proof
+	}
+	id := identity{"room", user}
+	req := signaling.Request{RoomID: "room", UserID: user}
+	replayed := prove(id, req, key)
+	if err := c.check(id, replayed); err != nil {
+		t.Fatal(err)
+	}
+	expired := prove(id, req, key)
+	c.mu.Lock()
+	ch := c.m[string(expired.Nonce)]
+	ch.expires = time.Now().Add(-time.Second)
+	c.m[string(expired.Nonce)] = ch
+	c.mu.Unlock()
+	tests := []struct {
+		name  string
+		id    identity
+		proof *signaling.Proof
+		fail  bool
+	}{
+		{"plain user", identity{"room", "alice"}, nil, false},
+		{"no proof", id, nil, true},
+		{"proven", id, prove(id, req, key), false},
+		{"replayed", id, replayed, true},
+		{"expired", id, expired, true},
+		{"other key", id, prove(id, req, other), true},
+		{"issued for another room", id, prove(identity{"other", user}, req, key), true},
+		{"signed for another room", id, prove(id, signaling.Request{RoomID: "other", UserID: user}, key), true},
+		{"unknown nonce", id, &signaling.Proof{Nonce: []byte("nonce")}, true},
+	}
+	for _, tt := range tests {
+		if err := c.check(tt.id, tt.proof); (err != nil) != tt.fail {
+			t.Errorf("%s: %v, want failure %v", tt.name, err, tt.fail)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nobonobo/p2pfw/signaling"
+)
+
+func init() { signaling.Verbose = false }
+
+func newTestSignaling(t *testing.T, broker signaling.Broker, id string) *Signaling {
+	t.Helper()
+	s, err := newSignaling(DefaultConfig(), signaling.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.broker, s.id = broker, id
+	return s
+}
+
+func request(user, preshared string) signaling.Request {
+	return signaling.Request{RoomID: "room", UserID: user, Preshared: preshared}
+}
+
+func TestCluster(t *testing.T) {
+	broker := signaling.NewLocalBroker()
+	a := newTestSignaling(t, broker, "a")
+	b := newTestSignaling(t, broker, "b")
+	create := &CreateRoom{Request: request("alice", "pw"), Capacity: 3}
+	if err := a.CreateRoom(create, nil); err != nil {
+		t.Fatal(err)
+	}
+	hasReplica := func() bool {
+		b.mutex.RLock()
+		defer b.mutex.RUnlock()
+		_, ok := b.rooms["room"]
+		return ok
+	}
+	steps := []struct {
+		name    string
+		act     func() error
+		fail    bool
+		replica bool     // b keeps a replica afterwards
+		members []string // seen from both instances, owner first
+	}{
+		{"wrong preshared", func() error { return b.Join(request("bob", "nope"), nil) }, true, false, []string{"alice"}},
+		{"duplicate create", func() error { return b.CreateRoom(&CreateRoom{Request: request("eve", "x")}, nil) }, true, false, []string{"alice"}},
+		{"join replica", func() error { return b.Join(request("bob", "pw"), nil) }, false, true, []string{"alice", "bob"}},
+		{"join origin", func() error { return a.Join(request("carol", "pw"), nil) }, false, true, []string{"alice", "bob", "carol"}},
+		{"capacity spans instances", func() error { return b.Join(request("dave", "pw"), nil) }, true, true, []string{"alice", "bob", "carol"}},
+		{"leave replica", func() error { return b.Leave(request("bob", "pw"), nil) }, false, false, []string{"alice", "carol"}},
+		{"rejoin replica", func() error { return b.Join(request("bob", "pw"), nil) }, false, true, []string{"alice", "bob", "carol"}},
+		{"ban across instances", func() error {
+			return a.Ban(&Ban{Request: request("alice", "pw"), Member: "bob"}, nil)
+		}, false, false, []string{"alice", "carol"}},
+		{"banned on replica", func() error { return b.Join(request("bob", "pw"), nil) }, true, false, []string{"alice", "carol"}},
+		{"ban elsewhere", func() error {
+			return a.Ban(&Ban{Request: request("alice", "pw"), Member: "dave"}, nil)
+		}, false, false, []string{"alice", "carol"}},
+		{"banned before joining", func() error { return b.Join(request("dave", "pw"), nil) }, true, false, []string{"alice", "carol"}},
+	}
+	for _, s := range steps {
+		err := s.act()
+		if (err != nil) != s.fail {
+			t.Fatalf("%s: %v, want failure %v", s.name, err, s.fail)
+		}
+		if hasReplica() != s.replica {
+			t.Errorf("%s: replica %v, want %v", s.name, !s.replica, s.replica)
+		}
+		var m signaling.Members
+		if err := a.Members(request("alice", "pw"), &m); err != nil {
+			t.Fatal(err)
+		}
+		if got := append([]string{m.Owner}, m.Member...); !reflect.DeepEqual(got, s.members) {
+			t.Errorf("%s: members on a %v, want %v", s.name, got, s.members)
+		}
+		if !s.replica {
+			continue
+		}
+		m = signaling.Members{}
+		if err := b.Members(request("bob", "pw"), &m); err != nil {
+			t.Fatal(err)
+		}
+		if got := append([]string{m.Owner}, m.Member...); !reflect.DeepEqual(got, s.members) {
+			t.Errorf("%s: members on b %v, want %v", s.name, got, s.members)
+		}
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config is read from defaults, then a YAML file (-config),
+// then SIGNALING_* environment variables, then flags.
+type Config struct {
+	Listen      string        `yaml:"listen"`
+	Timeout     time.Duration `yaml:"timeout"`   // member idle timeout
+	PullWait    time.Duration `yaml:"pull_wait"` // long poll wait of Pull
+	QueueSize   int           `yaml:"queue_size"`
+	CORSOrigins []string      `yaml:"cors_origins"`
+	IceServers  []string      `yaml:"ice_servers"`
+	Store       string        `yaml:"store"`  // room store file, empty is memory
+	Broker      string        `yaml:"broker"` // nats://[user:pass@|token@]host:port, empty is single instance
+	LogFile     string        `yaml:"log_file"`
+	Verbose     bool          `yaml:"verbose"`
+
+	// MaxEventSize limits Event.Value of Send, MaxRequestSize a JSON-RPC
+	// request over POST or a WebSocket frame.
+	MaxEventSize   int `yaml:"max_event_size"`
+	MaxRequestSize int `yaml:"max_request_size"`
+
+	DrainTimeout   time.Duration `yaml:"drain_timeout"`   // wait for in-flight requests on shutdown
+	ReconnectDelay time.Duration `yaml:"reconnect_delay"` // suggested to members on shutdown
+
+	// Token bucket limits in requests per second, 0 means unlimited.
+	RateConn    float64 `yaml:"rate_conn"` // per WebSocket connection or HTTP client IP
+	BurstConn   int     `yaml:"burst_conn"`
+	RateUser    float64 `yaml:"rate_user"` // per UserID in a room
+	BurstUser   int     `yaml:"burst_user"`
+	RateRoom    float64 `yaml:"rate_room"` // Send per room
+	BurstRoom   int     `yaml:"burst_room"`
+	RateCreate  float64 `yaml:"rate_create"` // CreateRoom per client IP
+	BurstCreate int     `yaml:"burst_create"`
+	RateVerify  float64 `yaml:"rate_verify"` // room secret checks of non-members per room
+	BurstVerify int     `yaml:"burst_verify"`
+	TrustProxy  bool    `yaml:"trust_proxy"` // client IP from X-Forwarded-For
+
+	MetricsListen string `yaml:"metrics_listen"` // serves counters at /debug/vars
+
+	// TURN servers are handed out with their credentials to room members
+	// only: the static TurnUsername and TurnCredential, or with TurnSecret
+	// (coturn --use-auth-secret) credentials minted per member for TurnTTL.
+	TurnServers    []string      `yaml:"turn_servers"`
+	TurnUsername   string        `yaml:"turn_username"`
+	TurnCredential string        `yaml:"turn_credential"`
+	TurnSecret     string        `yaml:"turn_secret"`
+	TurnTTL        time.Duration `yaml:"turn_ttl"`
+
+	// Tokens (JWT) signed with AuthSecret (HS*) or a key in AuthKeys (RS*, ES*).
+	AuthRequired bool     `yaml:"auth_required"`
+	AuthSecret   string   `yaml:"auth_secret"`
+	AuthKeys     []string `yaml:"auth_keys"` // PEM files of public keys or certificates
+	AuthIssuer   string   `yaml:"auth_issuer"`
+	AuthAudience string   `yaml:"auth_audience"`
+
+	// TLS with certificate files, reloaded when they change.
+	TLSCert string `yaml:"tls_cert"`
+	TLSKey  string `yaml:"tls_key"`
+	// TLS with certificates obtained via ACME for ACMEHosts.
+	ACMEHosts     []string `yaml:"acme_hosts"`
+	ACMEDirectory string   `yaml:"acme_directory"` // default Let's Encrypt
+	ACMECA        string   `yaml:"acme_ca"`        // PEM roots trusted for the directory
+	ACMEEmail     string   `yaml:"acme_email"`
+	ACMECache     string   `yaml:"acme_cache"`
+	ACMEHTTP      string   `yaml:"acme_http"` // listen address for http-01 challenges
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Listen:      "0.0.0.0:8080",
+		Timeout:     30 * time.Second,
+		PullWait:    3 * time.Second,
+		QueueSize:   1024,
+		CORSOrigins: []string{"*"},
+		IceServers:  []string{},
+		Verbose:     true,
+
+		MaxEventSize:   64 << 10,
+		MaxRequestSize: 256 << 10,
+
+		DrainTimeout:   10 * time.Second,
+		ReconnectDelay: 5 * time.Second,
+
+		RateConn:    50,
+		BurstConn:   200,
+		RateUser:    40,
+		BurstUser:   160,
+		RateRoom:    100,
+		BurstRoom:   200,
+		RateCreate:  0.2,
+		BurstCreate: 5,
+		RateVerify:  5,
+		BurstVerify: 20,
+
+		TurnServers: []string{},
+		TurnTTL:     time.Hour,
+
+		AuthKeys:  []string{},
+		ACMEHosts: []string{},
+		ACMECache: "acme-cache",
+	}
+}
+
+func splitList(s string) []string {
+	list := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// Set assigns a single option by its yaml key.
+func (c *Config) Set(key, value string) error {
+	var err error
+	switch key {
+	case "listen":
+		c.Listen = value
+	case "timeout":
+		c.Timeout, err = time.ParseDuration(value)
+	case "pull_wait":
+		c.PullWait, err = time.ParseDuration(value)
+	case "queue_size":
+		c.QueueSize, err = strconv.Atoi(value)
+	case "max_event_size":
+		c.MaxEventSize, err = strconv.Atoi(value)
+	case "max_request_size":
+		c.MaxRequestSize, err = strconv.Atoi(value)
+	case "cors_origins":
+		c.CORSOrigins = splitList(value)
+	case "ice_servers":
+		c.IceServers = splitList(value)
+	case "store":
+		c.Store = value
+	case "broker":
+		c.Broker = value
+	case "log_file":
+		c.LogFile = value
+	case "verbose":
+		c.Verbose, err = strconv.ParseBool(value)
+	case "drain_timeout":
+		c.DrainTimeout, err = time.ParseDuration(value)
+	case "reconnect_delay":
+		c.ReconnectDelay, err = time.ParseDuration(value)
+	case "rate_conn":
+		c.RateConn, err = strconv.ParseFloat(value, 64)
+	case "burst_conn":
+		c.BurstConn, err = strconv.Atoi(value)
+	case "rate_user":
+		c.RateUser, err = strconv.ParseFloat(value, 64)
+	case "burst_user":
+		c.BurstUser, err = strconv.Atoi(value)
+	case "rate_room":
+		c.RateRoom, err = strconv.ParseFloat(value, 64)
+	case "burst_room":
+		c.BurstRoom, err = strconv.Atoi(value)
+	case "rate_create":
+		c.RateCreate, err = strconv.ParseFloat(value, 64)
+	case "burst_create":
+		c.BurstCreate, err = strconv.Atoi(value)
+	case "rate_verify":
+		c.RateVerify, err = strconv.ParseFloat(value, 64)
+	case "burst_verify":
+		c.BurstVerify, err = strconv.Atoi(value)
+	case "trust_proxy":
+		c.TrustProxy, err = strconv.ParseBool(value)
+	case "metrics_listen":
+		c.MetricsListen = value
+	case "turn_servers":
+		c.TurnServers = splitList(value)
+	case "turn_username":
+		c.TurnUsername = value
+	case "turn_credential":
+		c.TurnCredential = value
+	case "turn_secret":
+		c.TurnSecret = value
+	case "turn_ttl":
+		c.TurnTTL, err = time.ParseDuration(value)
+	case "auth_required":
+		c.AuthRequired, err = strconv.ParseBool(value)
+	case "auth_secret":
+		c.AuthSecret = value
+	case "auth_keys":
+		c.AuthKeys = splitList(value)
+	case "auth_issuer":
+		c.AuthIssuer = value
+	case "auth_audience":
+		c.AuthAudience = value
+	case "tls_cert":
+		c.TLSCert = value
+	case "tls_key":
+		c.TLSKey = value
+	case "acme_hosts":
+		c.ACMEHosts = splitList(value)
+	case "acme_directory":
+		c.ACMEDirectory = value
+	case "acme_ca":
+		c.ACMECA = value
+	case "acme_email":
+		c.ACMEEmail = value
+	case "acme_cache":
+		c.ACMECache = value
+	case "acme_http":
+		c.ACMEHTTP = value
+	default:
+		return fmt.Errorf("unknown option: %s", key)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s: %q: %v", key, value, err)
+	}
+	return nil
+}
+
+var configKeys = []string{
+	"listen", "timeout", "pull_wait", "queue_size", "cors_origins",
+	"max_event_size", "max_request_size",
+	"ice_servers", "store", "broker", "log_file", "verbose",
+	"drain_timeout", "reconnect_delay",
+	"rate_conn", "burst_conn", "rate_user", "burst_user", "rate_room",
+	"burst_room", "rate_create", "burst_create", "rate_verify", "burst_verify",
+	"trust_proxy",
+	"metrics_listen",
+	"turn_servers", "turn_username", "turn_credential", "turn_secret", "turn_ttl",
+	"auth_required", "auth_secret", "auth_keys", "auth_issuer", "auth_audience",
+	"tls_cert", "tls_key", "acme_hosts", "acme_directory", "acme_ca",
+	"acme_email", "acme_cache", "acme_http",
+}
+
+// LoadConfig ...
+func LoadConfig(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	path := fs.String("config", os.Getenv("SIGNALING_CONFIG"), "config file (YAML)")
+	flags := map[string]*string{}
+	for _, key := range configKeys {
+		flags[key] = fs.String(strings.Replace(key, "_", "-", -1), "",
+			fmt.Sprintf("overrides %q of config file", key))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	c := DefaultConfig()
+	if len(*path) > 0 {
+		b, err := ioutil.ReadFile(*path)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.UnmarshalStrict(b, c); err != nil {
+			return nil, fmt.Errorf("%s: %v", *path, err)
+		}
+	}
+	// STUN is kept for existing deployments.
+	if v := os.Getenv("STUN"); len(v) > 0 {
+		c.IceServers = splitList(v)
+	}
+	for _, key := range configKeys {
+		env := "SIGNALING_" + strings.ToUpper(key)
+		if v, ok := os.LookupEnv(env); ok {
+			if err := c.Set(key, v); err != nil {
+				return nil, fmt.Errorf("%s: %v", env, err)
+			}
+		}
+	}
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		key := strings.Replace(f.Name, "-", "_", -1)
+		if _, ok := flags[key]; ok && err == nil {
+			if e := c.Set(key, f.Value.String()); e != nil {
+				err = fmt.Errorf("-%s: %v", f.Name, e)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, c.Validate()
+}
+
+// Validate ...
+func (c *Config) Validate() error {
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		return fmt.Errorf("invalid listen: %q: %v", c.Listen, err)
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("timeout must be positive: %s", c.Timeout)
+	}
+	if c.PullWait <= 0 || c.PullWait >= c.Timeout {
+		return fmt.Errorf("pull_wait must be positive and less than timeout(%s): %s",
+			c.Timeout, c.PullWait)
+	}
+	if c.MaxEventSize <= 0 || c.MaxRequestSize <= c.MaxEventSize {
+		return fmt.Errorf("max_event_size must be positive and less than max_request_size(%d): %d",
+			c.MaxRequestSize, c.MaxEventSize)
+	}
+	if c.DrainTimeout <= 0 {
+		return fmt.Errorf("drain_timeout must be positive: %s", c.DrainTimeout)
+	}
+	if c.ReconnectDelay < 0 {
+		return fmt.Errorf("reconnect_delay must not be negative: %s", c.ReconnectDelay)
+	}
+	if c.QueueSize <= 0 {
+		return fmt.Errorf("queue_size must be positive: %d", c.QueueSize)
+	}
+	for _, origin := range c.CORSOrigins {
+		if origin == "*" {
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+			return fmt.Errorf("invalid cors origin: %q (want scheme://host[:port] or *)", origin)
+		}
+	}
+	for _, ice := range c.IceServers {
+		scheme := strings.SplitN(ice, ":", 2)[0]
+		switch scheme {
+		case "stun", "stuns", "turn", "turns":
+		default:
+			return fmt.Errorf("invalid ice server: %q (want stun:, stuns:, turn: or turns:)", ice)
+		}
+	}
+	for _, turn := range c.TurnServers {
+		scheme := strings.SplitN(turn, ":", 2)[0]
+		if scheme != "turn" && scheme != "turns" {
+			return fmt.Errorf("invalid turn server: %q (want turn: or turns:)", turn)
+		}
+	}
+	if len(c.TurnServers) > 0 && len(c.TurnSecret) == 0 &&
+		(len(c.TurnUsername) == 0 || len(c.TurnCredential) == 0) {
+		return fmt.Errorf("turn_servers needs turn_secret, or turn_username and turn_credential")
+	}
+	if len(c.TurnSecret) > 0 && c.TurnTTL <= 0 {
+		return fmt.Errorf("turn_ttl must be positive: %s", c.TurnTTL)
+	}
+	if len(c.Broker) > 0 {
+		if u, err := url.Parse(c.Broker); err != nil || u.Scheme != "nats" || len(u.Host) == 0 {
+			return fmt.Errorf("invalid broker: %q (want nats://host:port)", c.Broker)
+		}
+	}
+	for name, rate := range map[string]float64{
+		"rate_conn": c.RateConn, "rate_user": c.RateUser,
+		"rate_room": c.RateRoom, "rate_create": c.RateCreate,
+		"rate_verify": c.RateVerify,
+	} {
+		if rate < 0 {
+			return fmt.Errorf("%s must not be negative: %v", name, rate)
+		}
+	}
+	if c.AuthRequired && len(c.AuthSecret) == 0 && len(c.AuthKeys) == 0 {
+		return fmt.Errorf("auth_required needs auth_secret or auth_keys")
+	}
+	if (len(c.TLSCert) > 0) != (len(c.TLSKey) > 0) {
+		return fmt.Errorf("tls_cert and tls_key must be set together")
+	}
+	if len(c.TLSCert) > 0 && len(c.ACMEHosts) > 0 {
+		return fmt.Errorf("tls_cert and acme_hosts are exclusive")
+	}
+	if len(c.ACMEDirectory) > 0 {
+		if u, err := url.Parse(c.ACMEDirectory); err != nil || u.Scheme != "https" {
+			return fmt.Errorf("invalid acme_directory: %q (want https URL)", c.ACMEDirectory)
+		}
+	}
+	if len(c.MetricsListen) > 0 {
+		if _, _, err := net.SplitHostPort(c.MetricsListen); err != nil {
+			return fmt.Errorf("invalid metrics_listen: %q: %v", c.MetricsListen, err)
+		}
+	}
+	if len(c.ACMEHTTP) > 0 {
+		if _, _, err := net.SplitHostPort(c.ACMEHTTP); err != nil {
+			return fmt.Errorf("invalid acme_http: %q: %v", c.ACMEHTTP, err)
+		}
+	}
+	return nil
+}
+
+// TLS reports whether the server terminates TLS itself.
+func (c *Config) TLS() bool {
+	return len(c.TLSCert) > 0 || len(c.ACMEHosts) > 0
+}
+
+// AllowedOrigin ...
+func (c *Config) AllowedOrigin(origin string) bool {
+	for _, o := range c.CORSOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.yaml")
+	if err := ioutil.WriteFile(path, []byte("listen: 127.0.0.1:9000\npull_wait: 2s\nqueue_size: 5\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		args  []string
+		env   map[string]string
+		fail  bool
+		check func(c *Config) bool
+	}{
+		{"defaults", nil, nil, false, func(c *Config) bool { return c.Listen == "0.0.0.0:8080" }},
+		{"file", []string{"-config", path}, nil, false, func(c *Config) bool {
+			return c.Listen == "127.0.0.1:9000" && c.PullWait == 2*time.Second && c.QueueSize == 5
+		}},
+		{"env over file", []string{"-config", path}, map[string]string{"SIGNALING_QUEUE_SIZE": "7"}, false,
+			func(c *Config) bool { return c.QueueSize == 7 }},
+		{"flag over env", []string{"-queue-size", "9"}, map[string]string{"SIGNALING_QUEUE_SIZE": "7"}, false,
+			func(c *Config) bool { return c.QueueSize == 9 }},
+		{"list", []string{"-cors-origins", "https://a.example, https://b.example"}, nil, false,
+			func(c *Config) bool { return len(c.CORSOrigins) == 2 && c.CORSOrigins[1] == "https://b.example" }},
+		{"bad duration", []string{"-timeout", "soon"}, nil, true, nil},
+		{"bad env", nil, map[string]string{"SIGNALING_TIMEOUT": "soon"}, true, nil},
+		{"unknown file key", []string{"-config", path + ".bad"}, nil, true, nil},
+	}
+	ioutil.WriteFile(path+".bad", []byte("no_such_option: 1\n"), 0600)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				os.Setenv(k, v)
+				defer os.Unsetenv(k)
+			}
+			c, err := LoadConfig(tt.args)
+			if (err != nil) != tt.fail {
+				t.Fatalf("got %v, want failure %v", err, tt.fail)
+			}
+			if tt.check != nil && !tt.check(c) {
+				t.Errorf("unexpected config %+v", c)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(c *Config)
+		fail bool
+	}{
+		{"default", func(c *Config) {}, false},
+		{"listen", func(c *Config) { c.Listen = "8080" }, true},
+		{"pull wait over timeout", func(c *Config) { c.PullWait = c.Timeout }, true},
+		{"event over request", func(c *Config) { c.MaxEventSize = c.MaxRequestSize }, true},
+		{"cors origin", func(c *Config) { c.CORSOrigins = []string{"example.com"} }, true},
+		{"ice server", func(c *Config) { c.IceServers = []string{"http://example.com"} }, true},
+		{"turn without credential", func(c *Config) { c.TurnServers = []string{"turn:example.com"} }, true},
+		{"turn with secret", func(c *Config) {
+			c.TurnServers, c.TurnSecret = []string{"turn:example.com"}, "s"
+		}, false},
+		{"broker", func(c *Config) { c.Broker = "tcp://example.com:4222" }, true},
+		{"negative rate", func(c *Config) { c.RateUser = -1 }, true},
+		{"auth without keys", func(c *Config) { c.AuthRequired = true }, true},
+		{"tls cert only", func(c *Config) { c.TLSCert = "cert.pem" }, true},
+		{"tls and acme", func(c *Config) {
+			c.TLSCert, c.TLSKey, c.ACMEHosts = "cert.pem", "key.pem", []string{"example.com"}
+		}, true},
+		{"acme directory", func(c *Config) { c.ACMEDirectory = "http://acme.example" }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := DefaultConfig()
+			tt.edit(c)
+			if err := c.Validate(); (err != nil) != tt.fail {
+				t.Errorf("got %v, want failure %v", err, tt.fail)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/rpc"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/nobonobo/p2pfw/signaling"
+)
+
+// inflight counts rpc requests between reading a request and
+// writing its response. Once the server drains it fails new requests
+// but Leave, so that the count drops to zero.
+type inflight struct {
+	rpc.ServerCodec
+	s      *Signaling
+	method string
+}
+
+// ReadRequestHeader ...
+func (c *inflight) ReadRequestHeader(r *rpc.Request) error {
+	err := c.ServerCodec.ReadRequestHeader(r)
+	if err == nil {
+		atomic.AddInt64(&c.s.inflight, 1)
+		c.method = r.ServiceMethod
+	}
+	return err
+}
+
+// ReadRequestBody ...
+func (c *inflight) ReadRequestBody(x interface{}) error {
+	if err := c.ServerCodec.ReadRequestBody(x); err != nil {
+		return err
+	}
+	if c.s.Draining() && c.method != "Signaling.Leave" {
+		return signaling.ErrGoingAway
+	}
+	return nil
+}
+
+// WriteResponse ...
+func (c *inflight) WriteResponse(r *rpc.Response, x interface{}) error {
+	defer atomic.AddInt64(&c.s.inflight, -1)
+	return c.ServerCodec.WriteResponse(r, x)
+}
+
+type conns struct {
+	sync.Mutex
+	m map[*websocket.Conn]struct{}
+}
+
+func (c *conns) add(ws *websocket.Conn) {
+	c.Lock()
+	c.m[ws] = struct{}{}
+	c.Unlock()
+}
+
+func (c *conns) del(ws *websocket.Conn) {
+	c.Lock()
+	delete(c.m, ws)
+	c.Unlock()
+}
+
+func (c *conns) closeAll() {
+	c.Lock()
+	defer c.Unlock()
+	for ws := range c.m {
+		ws.Close()
+	}
+}
+
+// Draining ...
+func (s *Signaling) Draining() bool {
+	return atomic.LoadInt32(&s.draining) != 0
+}
+
+// drain fails new requests, tells every member to reconnect later and
+// waits for in-flight requests until the deadline of ctx.
+func (s *Signaling) drain(ctx context.Context, srv *http.Server) {
+	atomic.StoreInt32(&s.draining, 1)
+	ev := signaling.New("", "", &signaling.GoingAway{
+		Reconnect: s.config.ReconnectDelay,
+	})
+	s.mutex.RLock()
+	for _, room := range s.rooms {
+		room.Iter(func(m *signaling.Member) { m.Push(ev) })
+	}
+	s.mutex.RUnlock()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Println("shutdown:", err)
+	}
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	for atomic.LoadInt64(&s.inflight) > 0 {
+		select {
+		case <-ctx.Done():
+			log.Println("drain timeout, in-flight:", atomic.LoadInt64(&s.inflight))
+			s.conns.closeAll()
+			s.saver.flush()
+			return
+		case <-tick.C:
+		}
+	}
+	s.conns.closeAll()
+	s.saver.flush()
+}
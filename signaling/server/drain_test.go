@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/rpc"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nobonobo/p2pfw/signaling"
+)
+
+// fakeCodec serves one request of method.
+type fakeCodec struct {
+	method string
+}
+
+func (c *fakeCodec) ReadRequestHeader(r *rpc.Request) error {
+	r.ServiceMethod = c.method
+	return nil
+}
+func (c *fakeCodec) ReadRequestBody(x interface{}) error                { return nil }
+func (c *fakeCodec) WriteResponse(r *rpc.Response, x interface{}) error { return nil }
+func (c *fakeCodec) Close() error                                       { return nil }
+
+func TestInflightDraining(t *testing.T) {
+	tests := []struct {
+		method   string
+		draining bool
+		fail     bool
+	}{
+		{"Signaling.Join", false, false},
+		{"Signaling.Join", true, true},
+		{"Signaling.Pull", true, true},
+		{"Signaling.Leave", true, false},
+	}
+	for _, tt := range tests {
+		s := &Signaling{}
+		if tt.draining {
+			atomic.StoreInt32(&s.draining, 1)
+		}
+		c := &inflight{ServerCodec: &fakeCodec{tt.method}, s: s}
+		var r rpc.Request
+		if err := c.ReadRequestHeader(&r); err != nil {
+			t.Fatal(err)
+		}
+		if n := atomic.LoadInt64(&s.inflight); n != 1 {
+			t.Errorf("%s: %d in flight", tt.method, n)
+		}
+		err := c.ReadRequestBody(nil)
+		if (err != nil) != tt.fail || tt.fail && !signaling.IsError(err, signaling.ErrGoingAway) {
+			t.Errorf("%s draining %v: %v", tt.method, tt.draining, err)
+		}
+		c.WriteResponse(&rpc.Response{}, nil)
+		if n := atomic.LoadInt64(&s.inflight); n != 0 {
+			t.Errorf("%s: %d in flight after the response", tt.method, n)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nobonobo/p2pfw/signaling"
+)
+
+// turnCredential mints a TURN REST API credential: the username is
+// "expiry:user" and the password its HMAC-SHA1 under the shared secret,
+// which coturn checks with --use-auth-secret.
+func turnCredential(secret, user string, expires time.Time) (username, credential string) {
+	username = fmt.Sprintf("%d:%s", expires.Unix(), user)
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	return username, base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// iceServers returns the configured servers, TURN ones only when user
+// (a member) is not empty.
+func (s *Signaling) iceServers(user string) []signaling.IceServer {
+	servers := []signaling.IceServer{}
+	if len(s.config.IceServers) > 0 {
+		servers = append(servers, signaling.IceServer{URLs: s.config.IceServers})
+	}
+	if len(user) == 0 || len(s.config.TurnServers) == 0 {
+		return servers
+	}
+	turn := signaling.IceServer{
+		URLs:       s.config.TurnServers,
+		Username:   s.config.TurnUsername,
+		Credential: s.config.TurnCredential,
+	}
+	if len(s.config.TurnSecret) > 0 {
+		turn.Expires = time.Now().Add(s.config.TurnTTL).Truncate(time.Second)
+		turn.Username, turn.Credential = turnCredential(s.config.TurnSecret, user, turn.Expires)
+	}
+	return append(servers, turn)
+}
+
+// IceServers returns the STUN servers, and the TURN servers with their
+// credentials when req is from a member. An empty req asks anonymously.
+// Minted credentials expire, members ask again before Expires.
+func (s *Signaling) IceServers(req signaling.Request, servers *[]signaling.IceServer) error {
+	if len(req.RoomID) == 0 && len(req.UserID) == 0 {
+		*servers = s.iceServers("")
+		return nil
+	}
+	if err := s.valid(&req, false); err != nil {
+		return err
+	}
+	room, err := s.room(req)
+	if err != nil {
+		return err
+	}
+	if room.Get(req.UserID) == nil {
+		return fmt.Errorf("you not a member: %s", req.UserID)
+	}
+	*servers = s.iceServers(req.UserID)
+	return nil
+}
+
+// getIceServers serves the anonymous answer of IceServers as JSON.
+func (s *Signaling) getIceServers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(s.iceServers("")); err != nil {
+		log.Println("ice servers:", err)
+	}
+}
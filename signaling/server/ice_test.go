@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nobonobo/p2pfw/signaling"
+)
+
+func TestTurnCredential(t *testing.T) {
+	expires := time.Unix(1700000000, 0)
+	username, credential := turnCredential("secret", "alice", expires)
+	if username != "1700000000:alice" {
+		t.Errorf("username %q", username)
+	}
+	// as coturn checks it with --use-auth-secret
+	mac := hmac.New(sha1.New, []byte("secret"))
+	mac.Write([]byte(username))
+	if want := base64.StdEncoding.EncodeToString(mac.Sum(nil)); credential != want {
+		t.Errorf("credential %q, want %q", credential, want)
+	}
+	if _, other := turnCredential("other", "alice", expires); other == credential {
+		t.Errorf("credential does not depend on the secret")
+	}
+}
+
+func TestIceServers(t *testing.T) {
+	tests := []struct {
+		name   string
+		config func(c *Config)
+		user   string
+		check  func(servers []signaling.IceServer) bool
+	}{
+		{"anonymous", func(c *Config) {}, "", func(s []signaling.IceServer) bool {
+			return len(s) == 1 && s[0].Username == ""
+		}},
+		{"static turn", func(c *Config) {}, "alice", func(s []signaling.IceServer) bool {
+			return len(s) == 2 && s[1].Username == "user" && s[1].Credential == "pass" && s[1].Expires.IsZero()
+		}},
+		{"minted turn", func(c *Config) { c.TurnSecret = "secret" }, "alice", func(s []signaling.IceServer) bool {
+			return len(s) == 2 && strings.HasSuffix(s[1].Username, ":alice") &&
+				s[1].Expires.After(time.Now().Add(time.Hour-time.Minute))
+		}},
+		{"no turn", func(c *Config) { c.TurnServers = nil }, "alice", func(s []signaling.IceServer) bool {
+			return len(s) == 1
+		}},
+		{"no stun", func(c *Config) { c.IceServers = nil }, "", func(s []signaling.IceServer) bool {
+			return len(s) == 0
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.IceServers = []string{"stun:stun.example.com:3478"}
+			config.TurnServers = []string{"turn:turn.example.com:3478"}
+			config.TurnUsername, config.TurnCredential = "user", "pass"
+			config.TurnTTL = time.Hour
+			tt.config(config)
+			s := &Signaling{config: config}
+			if servers := s.iceServers(tt.user); !tt.check(servers) {
+				t.Errorf("got %+v", servers)
+			}
+		})
+	}
+}
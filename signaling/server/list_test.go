@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nobonobo/p2pfw/signaling"
+)
+
+func TestListRooms(t *testing.T) {
+	s, err := newSignaling(DefaultConfig(), signaling.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*CreateRoom{
+		{Request: signaling.Request{RoomID: "chess", UserID: "a"}, Public: true, Title: "Chess club", Tags: []string{"game"}},
+		{Request: signaling.Request{RoomID: "go", UserID: "b"}, Public: true, Title: "Weiqi", Tags: []string{"game", "asia"}, Capacity: 1},
+		{Request: signaling.Request{RoomID: "music", UserID: "c"}, Public: true, Tags: []string{"jam"}},
+		{Request: signaling.Request{RoomID: "secret", UserID: "d"}},
+	} {
+		if err := s.CreateRoom(c, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name  string
+		query ListRooms
+		want  []string
+		total int
+	}{
+		{"all public", ListRooms{}, []string{"chess", "go", "music"}, 3},
+		{"query title", ListRooms{Query: "CLUB"}, []string{"chess"}, 1},
+		{"query id", ListRooms{Query: "mus"}, []string{"music"}, 1},
+		{"tags", ListRooms{Tags: []string{"game", "asia"}}, []string{"go"}, 1},
+		{"joinable", ListRooms{Joinable: true}, []string{"chess", "music"}, 2},
+		{"page", ListRooms{Offset: 1, Limit: 1}, []string{"go"}, 3},
+		{"past the end", ListRooms{Offset: 9}, []string{}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var list signaling.RoomList
+			if err := s.ListRooms(&tt.query, &list); err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, info := range list.Rooms {
+				got = append(got, info.RoomID)
+			}
+			if !reflect.DeepEqual(got, tt.want) || list.Total != tt.total {
+				t.Errorf("got %v of %d, want %v of %d", got, list.Total, tt.want, tt.total)
+			}
+		})
+	}
+	if err := s.ListRooms(&ListRooms{Offset: -1}, new(signaling.RoomList)); err == nil {
+		t.Errorf("negative offset accepted")
+	}
+}
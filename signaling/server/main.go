@@ -1,14 +1,23 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"expvar"
+	"flag"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"net/rpc"
-	"net/rpc/jsonrpc"
 	"os"
+	"os/signal"
+	"sort"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/rs/cors"
@@ -20,38 +29,208 @@ import (
 
 // Signaling ...
 type Signaling struct {
-	mutex sync.RWMutex
-	rooms map[string]*signaling.Room
+	mutex  sync.RWMutex
+	rooms  map[string]*signaling.Room
+	saver  *saver
+	broker signaling.Broker
+	id     string
+	config *Config
+	auth   *Authenticator
+	limits *Limits
+	proofs challenges
+
+	draining int32
+	inflight int64
+	conns    conns
 }
 
-// Pull ...
-func (s *Signaling) Pull(req signaling.Request, events *[]*signaling.Event) (er error) {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-	if err := req.Valid(); err != nil {
+// LookupTimeout bounds the wait for another instance to answer a room lookup.
+const LookupTimeout = 500 * time.Millisecond
+
+func newSignaling(config *Config, store signaling.RoomStore) (*Signaling, error) {
+	auth, err := NewAuthenticator(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Signaling{
+		rooms:  map[string]*signaling.Room{},
+		saver:  newSaver(store),
+		config: config,
+		auth:   auth,
+		limits: NewLimits(config),
+		conns:  conns{m: map[*websocket.Conn]struct{}{}},
+	}, nil
+}
+
+func (s *Signaling) restore() error {
+	states, err := s.saver.store.Load()
+	if err != nil {
 		return err
 	}
+	for _, state := range states {
+		room := signaling.RestoreRoom(state)
+		if err := s.addRoom(room, false); err != nil {
+			return err
+		}
+		// drop the room unless its owner comes back after the restart.
+		time.AfterFunc(signaling.TIMEOUT, func() {
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+			if s.rooms[room.Name()] == room && room.Get(room.Owner()) == nil {
+				s.destroyRoom(room)
+			}
+		})
+		log.Println("restore room:", room.Name())
+	}
+	return nil
+}
+
+func (s *Signaling) addRoom(room *signaling.Room, replica bool) error {
+	if s.broker != nil {
+		if err := room.Attach(s.broker, s.id, replica); err != nil {
+			return err
+		}
+	}
+	room.SetCheckFunc(func() {
+		s.mutex.Lock()
+		s.destroyRoom(room)
+		s.mutex.Unlock()
+	})
+	room.SetChangeFunc(func() { s.save(room) })
+	s.rooms[room.Name()] = room
+	return nil
+}
+
+// lookup asks other instances for a room unknown here and keeps a
+// replica of it, created tells whether the replica is a new one.
+func (s *Signaling) lookup(name string) (room *signaling.Room, created bool, err error) {
+	if s.broker == nil {
+		return nil, false, fmt.Errorf("not found room: %s", name)
+	}
+	states := make(chan *signaling.RoomState, 1)
+	unsub, err := s.broker.Subscribe(name, func(env *signaling.Envelope) {
+		if env.State != nil && env.Origin != s.id {
+			select {
+			case states <- env.State:
+			default:
+			}
+		}
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	defer unsub()
+	if err := s.broker.Publish(name, &signaling.Envelope{Origin: s.id, Lookup: true}); err != nil {
+		return nil, false, err
+	}
+	var state *signaling.RoomState
+	select {
+	case state = <-states:
+	case <-time.After(LookupTimeout):
+		return nil, false, fmt.Errorf("not found room: %s", name)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if room, ok := s.rooms[name]; ok {
+		return room, false, nil
+	}
+	room = signaling.RestoreRoom(state)
+	if err := s.addRoom(room, true); err != nil {
+		return nil, false, err
+	}
+	log.Println("replicate room:", room.Name())
+	return room, true, nil
+}
+
+// dropReplica forgets a replica nobody joined, so that failed joins
+// don't pile them up.
+func (s *Signaling) dropReplica(room *signaling.Room) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if room.Replica() && room.Empty() {
+		s.destroyRoom(room)
+	}
+}
+
+// room returns the room of req once req carries its secret. s.mutex is
+// held for the lookup only, as the check may run bcrypt and the room
+// may take s.mutex to destroy itself.
+func (s *Signaling) room(req signaling.Request) (*signaling.Room, error) {
+	s.mutex.RLock()
 	room, ok := s.rooms[req.RoomID]
+	s.mutex.RUnlock()
 	if !ok {
-		return fmt.Errorf("not found room: %s", req.RoomID)
+		return nil, fmt.Errorf("not found room: %s", req.RoomID)
+	}
+	if err := s.authorize(room, req); err != nil {
+		return nil, err
+	}
+	return room, nil
+}
+
+// authorize checks the room secret of req. Non-members are checked with
+// bcrypt, so their attempts are rate limited per room.
+func (s *Signaling) authorize(room *signaling.Room, req signaling.Request) error {
+	if room.Get(req.UserID) == nil {
+		if err := s.limits.verify.allow(room.Name()); err != nil {
+			return err
+		}
+	}
+	if !room.Authorized(req) {
+		return fmt.Errorf("mismatch preshared")
+	}
+	return nil
+}
+
+// valid checks req and authorizes its token for RoomID and UserID.
+func (s *Signaling) valid(req *signaling.Request, create bool) error {
+	if err := req.Valid(); err != nil {
+		return err
+	}
+	return s.auth.Authorize(req, create)
+}
+
+// save persists room in the background, replicas are saved by the
+// instance they come from.
+func (s *Signaling) save(room *signaling.Room) {
+	if !room.Replica() {
+		s.saver.save(room)
+	}
+}
+
+// Pull ...
+func (s *Signaling) Pull(req signaling.Request, events *[]*signaling.Event) (er error) {
+	if err := s.valid(&req, false); err != nil {
+		return err
 	}
-	if room.Preshared() != req.Preshared {
-		return fmt.Errorf("mismatch preshared: %s", req.Preshared)
+	room, err := s.room(req)
+	if err != nil {
+		return err
 	}
+	*events = []*signaling.Event{}
 	m := room.Get(req.UserID)
 	if m == nil {
-		return fmt.Errorf("not found member: %s", req.UserID)
+		// a knocking user waits for the owner's decision.
+		decision, ok := room.Wait(req.UserID)
+		if !ok {
+			return fmt.Errorf("not found member: %s", req.UserID)
+		}
+		select {
+		case event := <-decision:
+			*events = append(*events, event)
+		case <-time.After(s.config.PullWait):
+		}
+		return nil
 	}
-	*events = []*signaling.Event{}
 	m.Reset()
-	tm := time.NewTimer(3 * time.Second)
+	tm := time.NewTimer(s.config.PullWait)
 	select {
 	case event, ok := <-m.Pop():
 		if !ok {
 			return nil
 		}
 		*events = append(*events, event)
-		tm.Reset(3 * time.Second)
+		tm.Reset(s.config.PullWait)
 		for {
 			select {
 			case event, ok := <-m.Pop():
@@ -70,73 +249,237 @@ func (s *Signaling) Pull(req signaling.Request, events *[]*signaling.Event) (er
 }
 
 // CreateRoom ...
-func (s *Signaling) CreateRoom(req signaling.Request, none *struct{}) error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	if err := req.Valid(); err != nil {
+type CreateRoom struct {
+	signaling.Request
+	Capacity int  // 0 means unlimited
+	Knock    bool // queue joins on a full or locked room for approval
+	Public   bool // list the room in ListRooms
+	Title    string
+	Tags     []string
+	Kinds    []string // allowed Event.Kind of Send, empty means any
+}
+
+// CreateRoom ...
+func (s *Signaling) CreateRoom(req *CreateRoom, none *struct{}) error {
+	if err := s.valid(&req.Request, true); err != nil {
 		return err
 	}
-	if room, ok := s.rooms[req.RoomID]; ok {
-		if room.Owner() == req.UserID && room.Preshared() == req.Preshared {
-			return room.Join(req)
+	if s.Draining() {
+		return signaling.ErrGoingAway
+	}
+	if req.Capacity < 0 {
+		return fmt.Errorf("invalid capacity: %d", req.Capacity)
+	}
+	s.mutex.RLock()
+	_, ok := s.rooms[req.RoomID]
+	s.mutex.RUnlock()
+	if !ok && s.broker != nil {
+		if replica, created, err := s.lookup(req.RoomID); err == nil && created {
+			defer s.dropReplica(replica)
+		}
+	}
+	s.mutex.RLock()
+	room, ok := s.rooms[req.RoomID]
+	s.mutex.RUnlock()
+	if ok {
+		if room.Owner() == req.UserID && s.authorize(room, req.Request) == nil {
+			return room.Join(req.Request)
 		}
 		return fmt.Errorf("room name duplicated: %s", req.RoomID)
 	}
-	room := signaling.NewRoom(
+	// hashes the secret, so not under s.mutex.
+	room, err := signaling.NewRoom(
 		req.RoomID,
 		req.UserID,
 		req.Preshared,
 	)
-	room.SetCheckFunc(func() {
-		s.mutex.Lock()
-		s.destroyRoom(room)
-		s.mutex.Unlock()
-	})
-	s.rooms[room.Name()] = room
+	if err != nil {
+		return err
+	}
+	room.SetCapacity(req.Capacity)
+	room.SetKnocking(req.Knock)
+	room.SetPublic(req.Public, req.Title, req.Tags)
+	if len(req.Kinds) > 0 {
+		room.SetKinds(req.Kinds)
+	}
+	s.mutex.Lock()
+	if _, ok := s.rooms[req.RoomID]; ok {
+		err = fmt.Errorf("room name duplicated: %s", req.RoomID)
+	} else {
+		err = s.addRoom(room, false)
+	}
+	s.mutex.Unlock()
+	if err != nil {
+		room.Close()
+		return err
+	}
+	s.save(room)
 	log.Println("create room:", room.Name())
 	return nil
 }
 
+const (
+	// DefaultListLimit ...
+	DefaultListLimit = 50
+	// MaxListLimit ...
+	MaxListLimit = 200
+)
+
+// ListRooms ...
+type ListRooms struct {
+	Query    string   // substring of RoomID or Title, case insensitive
+	Tags     []string // rooms must have all of them
+	Joinable bool     // skip locked and full rooms
+	Offset   int
+	Limit    int
+	Token    string `json:",omitempty"`
+}
+
+// SetToken sets token unless the query carries its own.
+func (q *ListRooms) SetToken(token string) {
+	if len(q.Token) == 0 {
+		q.Token = token
+	}
+}
+
+func (q *ListRooms) match(info signaling.RoomInfo) bool {
+	if q.Joinable && !info.Joinable() {
+		return false
+	}
+	if len(q.Query) > 0 {
+		query := strings.ToLower(q.Query)
+		if !strings.Contains(strings.ToLower(info.RoomID), query) &&
+			!strings.Contains(strings.ToLower(info.Title), query) {
+			return false
+		}
+	}
+	for _, tag := range q.Tags {
+		found := false
+		for _, t := range info.Tags {
+			if t == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// ListRooms ...
+func (s *Signaling) ListRooms(req *ListRooms, list *signaling.RoomList) error {
+	if len(req.Token) > 0 || s.auth.required {
+		if _, err := s.auth.Verify(req.Token); err != nil {
+			return err
+		}
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if req.Offset < 0 {
+		return fmt.Errorf("invalid offset: %d", req.Offset)
+	}
+	limit := req.Limit
+	switch {
+	case limit <= 0:
+		limit = DefaultListLimit
+	case limit > MaxListLimit:
+		limit = MaxListLimit
+	}
+	rooms := []signaling.RoomInfo{}
+	for _, room := range s.rooms {
+		if !room.Public() {
+			continue
+		}
+		if info := room.Info(); req.match(info) {
+			rooms = append(rooms, info)
+		}
+	}
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].RoomID < rooms[j].RoomID
+	})
+	list.Total = len(rooms)
+	if req.Offset > len(rooms) {
+		req.Offset = len(rooms)
+	}
+	rooms = rooms[req.Offset:]
+	if len(rooms) > limit {
+		rooms = rooms[:limit]
+	}
+	list.Rooms = rooms
+	return nil
+}
+
 func (s *Signaling) destroyRoom(room *signaling.Room) {
+	if s.rooms[room.Name()] != room {
+		return
+	}
 	delete(s.rooms, room.Name())
 	room.Close()
+	if room.Replica() {
+		log.Println("drop replica:", room.Name())
+		return
+	}
+	s.saver.delete(room.Name())
 	log.Println("destroy room:", room.Name())
 }
 
 // DestroyRoom ...
 func (s *Signaling) DestroyRoom(req signaling.Request, none *struct{}) error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	if err := req.Valid(); err != nil {
+	if err := s.valid(&req, false); err != nil {
 		return err
 	}
-	room, ok := s.rooms[req.RoomID]
-	if !ok {
-		return fmt.Errorf("not found room: %s", req.RoomID)
-	}
-	if room.Preshared() != req.Preshared {
-		return fmt.Errorf("mismatch preshared: %s", req.Preshared)
+	room, err := s.room(req)
+	if err != nil {
+		return err
 	}
 	if room.Owner() != req.UserID {
 		return fmt.Errorf("no permission: %s", req.UserID)
 	}
+	s.mutex.Lock()
 	s.destroyRoom(room)
+	s.mutex.Unlock()
+	return nil
+}
+
+// Challenge returns a nonce to sign with the key a UserID is derived
+// from, see signaling.Prove.
+func (s *Signaling) Challenge(req signaling.Request, challenge *signaling.Challenge) error {
+	if err := s.valid(&req, false); err != nil {
+		return err
+	}
+	if _, ok := signaling.UserKey(req.UserID); !ok {
+		return fmt.Errorf("not a key derived UserID: %s", req.UserID)
+	}
+	c, err := s.proofs.issue(identity{req.RoomID, req.UserID})
+	if err != nil {
+		return err
+	}
+	*challenge = *c
 	return nil
 }
 
 // Join ...
 func (s *Signaling) Join(req signaling.Request, none *struct{}) error {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-	if err := req.Valid(); err != nil {
+	if err := s.valid(&req, false); err != nil {
 		return err
 	}
+	s.mutex.RLock()
 	room, ok := s.rooms[req.RoomID]
+	s.mutex.RUnlock()
 	if !ok {
-		return fmt.Errorf("not found room: %s", req.RoomID)
+		replica, created, err := s.lookup(req.RoomID)
+		if err != nil {
+			return err
+		}
+		if created {
+			defer s.dropReplica(replica)
+		}
+		room = replica
 	}
-	if room.Preshared() != req.Preshared {
-		return fmt.Errorf("mismatch preshared: %s", req.Preshared)
+	if err := s.authorize(room, req); err != nil {
+		return err
 	}
 	if err := room.Join(req); err != nil {
 		return err
@@ -146,17 +489,12 @@ func (s *Signaling) Join(req signaling.Request, none *struct{}) error {
 
 // Leave ...
 func (s *Signaling) Leave(req signaling.Request, none *struct{}) error {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-	if err := req.Valid(); err != nil {
+	if err := s.valid(&req, false); err != nil {
 		return err
 	}
-	room, ok := s.rooms[req.RoomID]
-	if !ok {
-		return fmt.Errorf("not found room: %s", req.RoomID)
-	}
-	if room.Preshared() != req.Preshared {
-		return fmt.Errorf("mismatch preshared: %s", req.Preshared)
+	room, err := s.room(req)
+	if err != nil {
+		return err
 	}
 	if err := room.Leave(req); err != nil {
 		return err
@@ -166,17 +504,12 @@ func (s *Signaling) Leave(req signaling.Request, none *struct{}) error {
 
 // Locked ...
 func (s *Signaling) Locked(req signaling.Request, locked *bool) error {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-	if err := req.Valid(); err != nil {
+	if err := s.valid(&req, false); err != nil {
 		return err
 	}
-	room, ok := s.rooms[req.RoomID]
-	if !ok {
-		return fmt.Errorf("not found room: %s", req.RoomID)
-	}
-	if room.Preshared() != req.Preshared {
-		return fmt.Errorf("mismatch preshared: %s", req.Preshared)
+	room, err := s.room(req)
+	if err != nil {
+		return err
 	}
 	if room.Get(req.UserID) == nil {
 		return fmt.Errorf("you not a member: %s", req.UserID)
@@ -193,92 +526,350 @@ type SetLocked struct {
 
 // SetLocked ...
 func (s *Signaling) SetLocked(req *SetLocked, none *struct{}) error {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-	if err := req.Valid(); err != nil {
+	if err := s.valid(&req.Request, false); err != nil {
 		return err
 	}
-	room, ok := s.rooms[req.RoomID]
-	if !ok {
-		return fmt.Errorf("not found room: %s", req.RoomID)
-	}
-	if room.Preshared() != req.Preshared {
-		return fmt.Errorf("mismatch preshared: %s", req.Preshared)
+	room, err := s.room(req.Request)
+	if err != nil {
+		return err
 	}
 	if room.Get(req.UserID) == nil {
 		return fmt.Errorf("you not a member: %s", req.UserID)
 	}
 	room.SetLocked(req.Locked)
+	s.save(room)
+	return nil
+}
+
+// SetPreshared ...
+type SetPreshared struct {
+	signaling.Request
+	NewPreshared string
+}
+
+// SetPreshared rotates the room secret. Current members stay joined.
+func (s *Signaling) SetPreshared(req *SetPreshared, none *struct{}) error {
+	if err := s.valid(&req.Request, false); err != nil {
+		return err
+	}
+	room, err := s.room(req.Request)
+	if err != nil {
+		return err
+	}
+	if room.Owner() != req.UserID {
+		return fmt.Errorf("no permission: %s", req.UserID)
+	}
+	if err := room.SetPreshared(req.NewPreshared); err != nil {
+		return err
+	}
+	s.save(room)
+	log.Println("rotate preshared:", room.Name())
+	return nil
+}
+
+// Kick ...
+type Kick struct {
+	signaling.Request
+	Member string
+	Reason string
+}
+
+// Kick ...
+func (s *Signaling) Kick(req *Kick, none *struct{}) error {
+	if err := s.valid(&req.Request, false); err != nil {
+		return err
+	}
+	room, err := s.room(req.Request)
+	if err != nil {
+		return err
+	}
+	if room.Owner() != req.UserID {
+		return fmt.Errorf("no permission: %s", req.UserID)
+	}
+	if err := room.Kick(req.Member, req.Reason); err != nil {
+		return err
+	}
+	log.Println("kick:", room.Name(), req.Member)
+	return nil
+}
+
+// Ban ...
+type Ban struct {
+	signaling.Request
+	Member   string
+	Reason   string
+	Duration time.Duration // 0 means forever
+}
+
+// Ban ...
+func (s *Signaling) Ban(req *Ban, none *struct{}) error {
+	if err := s.valid(&req.Request, false); err != nil {
+		return err
+	}
+	room, err := s.room(req.Request)
+	if err != nil {
+		return err
+	}
+	if room.Owner() != req.UserID {
+		return fmt.Errorf("no permission: %s", req.UserID)
+	}
+	if err := room.Ban(req.Member, req.Reason, req.Duration); err != nil {
+		return err
+	}
+	s.save(room)
+	log.Println("ban:", room.Name(), req.Member, req.Duration)
+	return nil
+}
+
+// Admit ...
+type Admit struct {
+	signaling.Request
+	Member string
+}
+
+// Approve ...
+func (s *Signaling) Approve(req *Admit, none *struct{}) error {
+	if err := s.valid(&req.Request, false); err != nil {
+		return err
+	}
+	room, err := s.room(req.Request)
+	if err != nil {
+		return err
+	}
+	if room.Owner() != req.UserID {
+		return fmt.Errorf("no permission: %s", req.UserID)
+	}
+	if err := room.Approve(req.Member); err != nil {
+		return err
+	}
+	s.save(room)
+	return nil
+}
+
+// Deny ...
+func (s *Signaling) Deny(req *Admit, none *struct{}) error {
+	if err := s.valid(&req.Request, false); err != nil {
+		return err
+	}
+	room, err := s.room(req.Request)
+	if err != nil {
+		return err
+	}
+	if room.Owner() != req.UserID {
+		return fmt.Errorf("no permission: %s", req.UserID)
+	}
+	if err := room.Deny(req.Member); err != nil {
+		return err
+	}
+	s.save(room)
 	return nil
 }
 
 // Members ...
 func (s *Signaling) Members(req signaling.Request, members *signaling.Members) error {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-	if err := req.Valid(); err != nil {
+	if err := s.valid(&req, false); err != nil {
 		return err
 	}
-	room, ok := s.rooms[req.RoomID]
-	if !ok {
-		return fmt.Errorf("not found room: %s", req.RoomID)
-	}
-	if room.Preshared() != req.Preshared {
-		return fmt.Errorf("mismatch preshared: %s", req.Preshared)
+	room, err := s.room(req)
+	if err != nil {
+		return err
 	}
 	if room.Get(req.UserID) == nil {
 		return fmt.Errorf("you not a member: %s", req.UserID)
 	}
-	room.Iter(func(m *signaling.Member) {
-		if room.Owner() == m.UserID {
-			(*members).Owner = m.UserID
-			return
+	for _, user := range room.MemberIDs() {
+		if room.Owner() == user {
+			(*members).Owner = user
+			continue
 		}
-		(*members).Member = append((*members).Member, m.UserID)
-	})
+		(*members).Member = append((*members).Member, user)
+	}
+	if room.Owner() == req.UserID {
+		(*members).Waiting = room.Waiting()
+	}
 	return nil
 }
 
 // Send ...
 func (s *Signaling) Send(msg signaling.Message, none *struct{}) error {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-	if err := msg.Valid(); err != nil {
+	if err := s.valid(&msg.Request, false); err != nil {
 		return err
 	}
-	room, ok := s.rooms[msg.RoomID]
-	if !ok {
-		return fmt.Errorf("not found room: %s", msg.RoomID)
+	if msg.Event == nil {
+		return fmt.Errorf("must set Event")
 	}
-	if room.Preshared() != msg.Preshared {
-		return fmt.Errorf("mismatch preshared: %s", msg.Preshared)
+	if n := len(msg.Event.Value); n > s.config.MaxEventSize {
+		return fmt.Errorf("event too large: %d bytes (max %d)", n, s.config.MaxEventSize)
+	}
+	room, err := s.room(msg.Request)
+	if err != nil {
+		return err
+	}
+	if !room.AllowKind(msg.Event.Kind) {
+		return fmt.Errorf("kind not allowed: %q", msg.Event.Kind)
 	}
 	return room.Send(msg)
 }
 
-func wsHandle(ws *websocket.Conn) {
+func (s *Signaling) wsHandle(ws *websocket.Conn) {
 	log.Println("connect:", ws.Request().RemoteAddr)
 	defer log.Println("disconnect:", ws.Request().RemoteAddr)
-	jsonrpc.ServeConn(ws)
+	s.conns.add(ws)
+	defer s.conns.del(ws)
+	var codec rpc.ServerCodec = newMessageCodec(ws, s.config.MaxRequestSize)
+	if token := handshakeToken(ws.Request()); len(token) > 0 {
+		codec = &withToken{codec, token}
+	}
+	codec = &bindIdentity{ServerCodec: codec, pending: map[uint64]binding{}, proofs: &s.proofs}
+	ip := s.limits.RemoteIP(ws.Request())
+	codec = &rateLimited{ServerCodec: codec, limits: s.limits, conn: fmt.Sprintf("%p", ws), ip: ip}
+	rpc.ServeCodec(&inflight{ServerCodec: codec, s: s})
+}
+
+// httpCodec decorates the codec of a JSON-RPC over POST request.
+func (s *Signaling) httpCodec(r *http.Request, codec rpc.ServerCodec) rpc.ServerCodec {
+	if token := handshakeToken(r); len(token) > 0 {
+		codec = &withToken{codec, token}
+	}
+	// a single request, so it proves its identity every time.
+	codec = &bindIdentity{ServerCodec: codec, pending: map[uint64]binding{}, proofs: &s.proofs, auth: s.auth}
+	ip := s.limits.RemoteIP(r)
+	return &rateLimited{ServerCodec: codec, limits: s.limits, conn: ip, ip: ip}
+}
+
+// limitBody rejects JSON-RPC over POST requests larger than MaxRequestSize.
+func (s *Signaling) limitBody(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		max := int64(s.config.MaxRequestSize)
+		if r.ContentLength > max {
+			http.Error(w, fmt.Sprintf("request too large: %d bytes (max %d)",
+				r.ContentLength, max), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (s *Signaling) wsHandshake(config *websocket.Config, r *http.Request) error {
+	origin, err := websocket.Origin(config, r)
+	if err == nil && origin == nil {
+		err = fmt.Errorf("null origin")
+	}
+	if err != nil {
+		return err
+	}
+	if !s.config.AllowedOrigin(origin.Scheme + "://" + origin.Host) {
+		return fmt.Errorf("origin not allowed: %s", origin)
+	}
+	config.Origin = origin
+	return nil
 }
 
-func getStun(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprint(w, os.Getenv("STUN"))
+func (s *Signaling) getStun(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, strings.Join(s.config.IceServers, ","))
 }
 
 func main() {
-	rpc.Register(&Signaling{rooms: map[string]*signaling.Room{}})
-	l, err := net.Listen("tcp", "0.0.0.0:8080")
+	config, err := LoadConfig(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalln("config:", err)
+	}
+	if len(config.LogFile) > 0 {
+		f, err := os.OpenFile(config.LogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer f.Close()
+		log.SetOutput(f)
+	}
+	signaling.N = config.QueueSize
+	signaling.TIMEOUT = config.Timeout
+	signaling.Verbose = config.Verbose
+	var store signaling.RoomStore = signaling.NewMemoryStore()
+	if len(config.Store) > 0 {
+		if store, err = signaling.NewFileStore(config.Store); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	sig, err := newSignaling(config, store)
+	if err != nil {
+		log.Fatalln("auth:", err)
+	}
+	if len(config.Broker) > 0 {
+		broker, err := signaling.DialNATS(config.Broker)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		sig.broker = broker
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			log.Fatalln(err)
+		}
+		sig.id = hex.EncodeToString(b)
+		log.Println("broker:", broker, "instance:", sig.id)
+	}
+	if err := sig.restore(); err != nil {
+		log.Fatalln(err)
+	}
+	rpc.Register(sig)
+	tlsConfig, err := config.TLSConfig()
+	if err != nil {
+		log.Fatalln("tls:", err)
+	}
+	l, err := net.Listen("tcp", config.Listen)
 	if err != nil {
 		log.Fatalln(err)
 	}
-	http.Handle("/ws", websocket.Handler(wsHandle))
-	http.Handle("/stun", cors.Default().Handler(
-		http.HandlerFunc(getStun)),
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
+	c := cors.New(cors.Options{
+		AllowedOrigins: config.CORSOrigins,
+		AllowedMethods: []string{"GET", "POST", "HEAD"},
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/ws", websocket.Server{
+		Handshake: sig.wsHandshake,
+		Handler:   sig.wsHandle,
+	})
+	mux.Handle("/stun", c.Handler(
+		http.HandlerFunc(sig.getStun)),
+	)
+	mux.Handle("/ice-servers", c.Handler(
+		http.HandlerFunc(sig.getIceServers)),
 	)
-	http.Handle("/", cors.Default().Handler(jrpc.Handle))
+	mux.Handle("/", c.Handler(sig.limitBody(jrpc.Handler(sig.httpCodec))))
+	if len(config.MetricsListen) > 0 {
+		// kept off the public listener, expvar also publishes os.Args.
+		go func() {
+			log.Println("metrics:", config.MetricsListen)
+			if err := http.ListenAndServe(config.MetricsListen, expvar.Handler()); err != nil {
+				log.Fatalln(err)
+			}
+		}()
+	}
+	srv := &http.Server{Handler: mux}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGTERM, os.Interrupt)
+		log.Println("signal:", <-sigs)
+		ctx, cancel := context.WithTimeout(context.Background(), config.DrainTimeout)
+		defer cancel()
+		sig.drain(ctx, srv)
+		if sig.broker != nil {
+			sig.broker.Close()
+		}
+	}()
 	log.Println("signaling server:", l.Addr())
-	if err := http.Serve(l, nil); err != nil {
+	if err := srv.Serve(l); err != http.ErrServerClosed {
 		log.Fatalln(err)
 	}
+	<-done
+	log.Println("signaling server stopped")
 }
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+
+	"golang.org/x/net/websocket"
+)
+
+// messageConn reads a WebSocket message by message, so that
+// MaxPayloadBytes bounds each frame, and fails once the request being
+// read grows past max bytes over several messages.
+type messageConn struct {
+	ws   *websocket.Conn
+	max  int
+	buf  []byte
+	read int // bytes of the current request
+}
+
+// Read ...
+func (c *messageConn) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		var msg []byte
+		if err := websocket.Message.Receive(c.ws, &msg); err != nil {
+			return 0, err
+		}
+		c.read += len(msg)
+		if c.read > c.max {
+			return 0, fmt.Errorf("request too large: %d bytes (max %d)", c.read, c.max)
+		}
+		c.buf = msg
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// Write ...
+func (c *messageConn) Write(p []byte) (int, error) { return c.ws.Write(p) }
+
+// Close ...
+func (c *messageConn) Close() error { return c.ws.Close() }
+
+// messageCodec is the JSON-RPC codec of a WebSocket connection, it limits
+// each request to max bytes.
+type messageCodec struct {
+	rpc.ServerCodec
+	conn *messageConn
+}
+
+func newMessageCodec(ws *websocket.Conn, max int) *messageCodec {
+	ws.MaxPayloadBytes = max
+	conn := &messageConn{ws: ws, max: max}
+	return &messageCodec{ServerCodec: jsonrpc.NewServerCodec(conn), conn: conn}
+}
+
+// ReadRequestHeader ...
+func (c *messageCodec) ReadRequestHeader(r *rpc.Request) error {
+	c.conn.read = 0
+	return c.ServerCodec.ReadRequestHeader(r)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/rpc"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestMessageCodec(t *testing.T) {
+	const max = 64
+	small := `{"method":"Signaling.Pull","params":[{}],"id":1}`
+	tests := []struct {
+		name     string
+		messages []string
+		want     []string // methods read before the codec fails
+	}{
+		{"one per message", []string{small, small}, []string{"Signaling.Pull", "Signaling.Pull"}},
+		{"oversized frame", []string{small, small + strings.Repeat(" ", max)}, []string{"Signaling.Pull"}},
+		{"split request", []string{small[:30], small[30:]}, []string{"Signaling.Pull"}},
+		{"oversized split request", []string{small[:40] + strings.Repeat(" ", 20), small[40:]}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			read := make(chan []string, 1)
+			server := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+				c := newMessageCodec(ws, max)
+				var methods []string
+				for {
+					var r rpc.Request
+					if err := c.ReadRequestHeader(&r); err != nil {
+						break
+					}
+					c.ReadRequestBody(nil)
+					methods = append(methods, r.ServiceMethod)
+				}
+				read <- methods
+			}))
+			defer server.Close()
+			ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http"), "", server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, m := range tt.messages {
+				if err := websocket.Message.Send(ws, m); err != nil {
+					t.Fatal(err)
+				}
+			}
+			ws.Close()
+			if got := <-read; strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("read %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
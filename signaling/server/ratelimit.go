@@ -0,0 +1,165 @@
+package main
+
+import (
+	"expvar"
+	"math"
+	"net"
+	"net/http"
+	"net/rpc"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nobonobo/p2pfw/signaling"
+)
+
+// throttled counts rejected requests by scope, served on /debug/vars.
+var throttled = expvar.NewMap("throttled")
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter is a set of token buckets keyed by string.
+type limiter struct {
+	scope   string
+	rate    float64 // tokens per second, 0 means unlimited
+	burst   float64
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+func newLimiter(scope string, rate float64, burst int) *limiter {
+	return &limiter{
+		scope:   scope,
+		rate:    rate,
+		burst:   math.Max(float64(burst), 1),
+		buckets: map[string]*bucket{},
+	}
+}
+
+// allow takes a token for key or returns the error to reply with.
+func (l *limiter) allow(key string) error {
+	if l.rate <= 0 {
+		return nil
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return nil
+	}
+	throttled.Add(l.scope, 1)
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return &signaling.RateLimitError{Scope: l.scope, RetryAfter: wait.Round(time.Millisecond)}
+}
+
+// sweep forgets buckets which have been full for a while.
+func (l *limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+	l.swept = now
+	idle := time.Duration(l.burst/l.rate*float64(time.Second)) + time.Minute
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > idle {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Limits ...
+type Limits struct {
+	conn   *limiter
+	user   *limiter
+	room   *limiter
+	create *limiter
+	verify *limiter // room secret checks of non-members per room
+	proxy  bool
+}
+
+// NewLimits ...
+func NewLimits(c *Config) *Limits {
+	return &Limits{
+		conn:   newLimiter("conn", c.RateConn, c.BurstConn),
+		user:   newLimiter("user", c.RateUser, c.BurstUser),
+		room:   newLimiter("room", c.RateRoom, c.BurstRoom),
+		create: newLimiter("create", c.RateCreate, c.BurstCreate),
+		verify: newLimiter("verify", c.RateVerify, c.BurstVerify),
+		proxy:  c.TrustProxy,
+	}
+}
+
+// RemoteIP ...
+func (l *Limits) RemoteIP(r *http.Request) string {
+	if l.proxy {
+		if fwd := r.Header.Get("X-Forwarded-For"); len(fwd) > 0 {
+			return strings.TrimSpace(strings.Split(fwd, ",")[0])
+		}
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// rateLimited applies the limits to each request read from a connection.
+type rateLimited struct {
+	rpc.ServerCodec
+	limits *Limits
+	conn   string // key of the connection, the remote IP for HTTP
+	ip     string
+	method string
+}
+
+// ReadRequestHeader ...
+func (c *rateLimited) ReadRequestHeader(r *rpc.Request) error {
+	err := c.ServerCodec.ReadRequestHeader(r)
+	if err == nil {
+		c.method = r.ServiceMethod
+	}
+	return err
+}
+
+// ReadRequestBody ...
+func (c *rateLimited) ReadRequestBody(x interface{}) error {
+	if err := c.ServerCodec.ReadRequestBody(x); err != nil {
+		return err
+	}
+	if x == nil {
+		return nil
+	}
+	if err := c.limits.conn.allow(c.conn); err != nil {
+		return err
+	}
+	if c.method == "Signaling.CreateRoom" {
+		if err := c.limits.create.allow(c.ip); err != nil {
+			return err
+		}
+	}
+	req, ok := x.(identifier)
+	if !ok {
+		return nil
+	}
+	room, user := req.Identity()
+	if err := c.limits.user.allow(room + "\x00" + user); err != nil {
+		return err
+	}
+	if c.method == "Signaling.Send" {
+		if err := c.limits.room.allow(room); err != nil {
+			return err
+		}
+	}
+	return nil
+}
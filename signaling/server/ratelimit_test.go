@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/rpc"
+	"testing"
+	"time"
+
+	"github.com/nobonobo/p2pfw/signaling"
+)
+
+func TestLimiter(t *testing.T) {
+	tests := []struct {
+		name  string
+		rate  float64
+		burst int
+		idle  time.Duration // before the last request
+		n     int           // requests allowed in a row
+		retry time.Duration // RetryAfter of the next one
+	}{
+		{"unlimited", 0, 0, 0, 1000, 0},
+		{"burst", 10, 5, 0, 5, 100 * time.Millisecond},
+		{"burst at least one", 2, 0, 0, 1, 500 * time.Millisecond},
+		{"refilled", 10, 5, 200 * time.Millisecond, 7, 100 * time.Millisecond},
+		{"refilled up to burst", 10, 5, time.Hour, 10, 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newLimiter("test", tt.rate, tt.burst)
+			allowed := 0
+			if tt.idle > 0 {
+				// spend the burst, then let the bucket refill.
+				for l.allow("key") == nil {
+					allowed++
+				}
+				l.buckets["key"].last = time.Now().Add(-tt.idle)
+			}
+			var err error
+			for ; allowed <= tt.n; allowed++ {
+				if err = l.allow("key"); err != nil {
+					break
+				}
+			}
+			if tt.rate <= 0 {
+				if err != nil {
+					t.Errorf("unlimited: %v", err)
+				}
+				return
+			}
+			e, ok := signaling.AsRateLimitError(err)
+			if allowed != tt.n || !ok || e.Scope != "test" {
+				t.Fatalf("allowed %d then %v, want %d", allowed, err, tt.n)
+			}
+			if d := e.RetryAfter - tt.retry; d < -5*time.Millisecond || d > 5*time.Millisecond {
+				t.Errorf("retry after %s, want %s", e.RetryAfter, tt.retry)
+			}
+			if l.allow("other") != nil {
+				t.Errorf("keys share a bucket")
+			}
+		})
+	}
+}
+
+func TestRateLimited(t *testing.T) {
+	config := DefaultConfig()
+	config.RateConn, config.BurstConn = 1, 5
+	config.RateUser, config.BurstUser = 1, 2
+	config.RateRoom, config.BurstRoom = 1, 1
+	config.RateCreate, config.BurstCreate = 1, 1
+	limits := NewLimits(config)
+	tests := []struct {
+		method string
+		user   string
+		scope  string // of the error, none when allowed
+	}{
+		{"Signaling.CreateRoom", "alice", ""},
+		{"Signaling.CreateRoom", "bob", "create"},
+		{"Signaling.Send", "alice", ""},
+		{"Signaling.Send", "alice", "user"},
+		{"Signaling.Send", "carol", "room"},
+		{"Signaling.Pull", "dave", "conn"},
+	}
+	body := &bodyCodec{}
+	c := &rateLimited{ServerCodec: body, limits: limits, conn: "conn", ip: "192.0.2.1"}
+	for i, tt := range tests {
+		body.method = tt.method
+		body.body = signaling.Request{RoomID: "room", UserID: tt.user}
+		if err := c.ReadRequestHeader(new(rpc.Request)); err != nil {
+			t.Fatal(err)
+		}
+		err := c.ReadRequestBody(new(signaling.Request))
+		e, _ := signaling.AsRateLimitError(err)
+		scope := ""
+		if e != nil {
+			scope = e.Scope
+		}
+		if scope != tt.scope {
+			t.Errorf("%d %s %s: %v, want scope %q", i, tt.method, tt.user, err, tt.scope)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log"
+	"sync"
+
+	"github.com/nobonobo/p2pfw/signaling"
+)
+
+// saver writes rooms to the store in the background, so that the store
+// I/O never runs under Signaling.mutex. Changes made to a room before
+// it is written coalesce into one write.
+type saver struct {
+	store   signaling.RoomStore
+	mu      sync.Mutex
+	pending map[string]*signaling.Room // nil deletes the room
+	wake    chan struct{}
+	write   sync.Mutex
+}
+
+func newSaver(store signaling.RoomStore) *saver {
+	sv := &saver{
+		store:   store,
+		pending: map[string]*signaling.Room{},
+		wake:    make(chan struct{}, 1),
+	}
+	go sv.run()
+	return sv
+}
+
+func (sv *saver) queue(name string, room *signaling.Room) {
+	sv.mu.Lock()
+	sv.pending[name] = room
+	sv.mu.Unlock()
+	select {
+	case sv.wake <- struct{}{}:
+	default:
+	}
+}
+
+// save ...
+func (sv *saver) save(room *signaling.Room) { sv.queue(room.Name(), room) }
+
+// delete ...
+func (sv *saver) delete(name string) { sv.queue(name, nil) }
+
+func (sv *saver) run() {
+	for range sv.wake {
+		sv.flush()
+	}
+}
+
+// flush writes the pending changes now.
+func (sv *saver) flush() {
+	sv.write.Lock()
+	defer sv.write.Unlock()
+	sv.mu.Lock()
+	pending := sv.pending
+	sv.pending = map[string]*signaling.Room{}
+	sv.mu.Unlock()
+	for name, room := range pending {
+		var err error
+		if room == nil {
+			err = sv.store.Delete(name)
+		} else {
+			err = sv.store.Save(room.State())
+		}
+		if err != nil {
+			log.Println("save room failed:", name, err)
+		}
+	}
+}
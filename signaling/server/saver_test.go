@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/nobonobo/p2pfw/signaling"
+)
+
+func TestSaver(t *testing.T) {
+	a, err := signaling.NewRoom("a", "alice", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b := signaling.RestoreRoom(&signaling.RoomState{Name: "b", Owner: "bob"})
+	tests := []struct {
+		name string
+		ops  func(sv *saver)
+		want []string
+	}{
+		{"save", func(sv *saver) { sv.save(a) }, []string{"a"}},
+		{"save delete", func(sv *saver) { sv.save(a); sv.delete("a") }, nil},
+		{"delete save", func(sv *saver) { sv.delete("a"); sv.save(a) }, []string{"a"}},
+		{"two rooms", func(sv *saver) { sv.save(a); sv.save(b); sv.save(a) }, []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := signaling.NewMemoryStore()
+			sv := newSaver(store)
+			tt.ops(sv)
+			sv.flush()
+			states, _ := store.Load()
+			got := map[string]bool{}
+			for _, s := range states {
+				got[s.Name] = true
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			for _, name := range tt.want {
+				if !got[name] {
+					t.Errorf("%s not saved", name)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// CertReloadInterval ...
+const CertReloadInterval = 10 * time.Second
+
+// certLoader serves a certificate pair from files and reloads it
+// when either file is modified.
+type certLoader struct {
+	certFile string
+	keyFile  string
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modTime  time.Time
+	checked  time.Time
+}
+
+func newCertLoader(certFile, keyFile string) (*certLoader, error) {
+	l := &certLoader{certFile: certFile, keyFile: keyFile}
+	if err := l.reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *certLoader) reload() error {
+	var modTime time.Time
+	for _, name := range []string{l.certFile, l.keyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		if fi.ModTime().After(modTime) {
+			modTime = fi.ModTime()
+		}
+	}
+	l.checked = time.Now()
+	if l.cert != nil && !modTime.After(l.modTime) {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+	if err != nil {
+		return err
+	}
+	if l.cert != nil {
+		log.Println("reload certificate:", l.certFile)
+	}
+	l.cert = &cert
+	l.modTime = modTime
+	return nil
+}
+
+// GetCertificate ...
+func (l *certLoader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if time.Since(l.checked) > CertReloadInterval {
+		if err := l.reload(); err != nil {
+			// keep serving the old one, files may be half written.
+			log.Println("reload certificate failed:", err)
+		}
+	}
+	return l.cert, nil
+}
+
+// TLSConfig returns nil when the server should serve plain HTTP.
+func (c *Config) TLSConfig() (*tls.Config, error) {
+	switch {
+	case len(c.TLSCert) > 0:
+		l, err := newCertLoader(c.TLSCert, c.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		return &tls.Config{GetCertificate: l.GetCertificate}, nil
+	case len(c.ACMEHosts) > 0:
+		client := &acme.Client{DirectoryURL: c.ACMEDirectory}
+		if len(c.ACMECA) > 0 {
+			pem, err := ioutil.ReadFile(c.ACMECA)
+			if err != nil {
+				return nil, err
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificate found: %s", c.ACMECA)
+			}
+			client.HTTPClient = &http.Client{Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			}}
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(c.ACMEHosts...),
+			Cache:      autocert.DirCache(c.ACMECache),
+			Email:      c.ACMEEmail,
+			Client:     client,
+		}
+		if len(c.ACMEHTTP) > 0 {
+			go func() {
+				log.Println("acme http-01:", c.ACMEHTTP)
+				if err := http.ListenAndServe(c.ACMEHTTP, m.HTTPHandler(nil)); err != nil {
+					log.Fatalln(err)
+				}
+			}()
+		}
+		return m.TLSConfig(), nil
+	}
+	return nil, nil
+}
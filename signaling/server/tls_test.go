@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate for name and returns its DER.
+func writeCert(t *testing.T, certFile, keyFile, name string, mtime time.Time) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kb, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for file, block := range map[string]*pem.Block{
+		certFile: {Type: "CERTIFICATE", Bytes: der},
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: kb},
+	} {
+		if err := ioutil.WriteFile(file, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return der
+}
+
+func TestCertReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	now := time.Now()
+	first := writeCert(t, certFile, keyFile, "first.example", now.Add(-time.Minute))
+	l, err := newCertLoader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		name   string
+		update func() []byte // returns the certificate expected next
+	}{
+		{"unchanged", func() []byte { return first }},
+		{"renewed", func() []byte {
+			return writeCert(t, certFile, keyFile, "second.example", now)
+		}},
+		{"half written", func() []byte {
+			cert, _ := l.GetCertificate(nil)
+			ioutil.WriteFile(keyFile, []byte("garbage"), 0600)
+			os.Chtimes(keyFile, now.Add(time.Minute), now.Add(time.Minute))
+			return cert.Certificate[0]
+		}},
+	}
+	for _, s := range steps {
+		want := s.update()
+		// as if CertReloadInterval passed.
+		l.mu.Lock()
+		l.checked = time.Time{}
+		l.mu.Unlock()
+		cert, err := l.GetCertificate(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		if !bytes.Equal(cert.Certificate[0], want) {
+			t.Errorf("%s: served another certificate", s.name)
+		}
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, "example", time.Now())
+	tests := []struct {
+		name   string
+		config Config
+		tls    bool
+		fail   bool
+	}{
+		{"plain", Config{}, false, false},
+		{"files", Config{TLSCert: certFile, TLSKey: keyFile}, true, false},
+		{"missing files", Config{TLSCert: certFile + ".none", TLSKey: keyFile}, false, true},
+		{"acme", Config{ACMEHosts: []string{"example.com"}, ACMECache: dir}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := tt.config.TLSConfig()
+			if (err != nil) != tt.fail || (c != nil) != tt.tls {
+				t.Errorf("got %v, %v", c != nil, err)
+			}
+		})
+	}
+}
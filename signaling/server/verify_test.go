@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/nobonobo/p2pfw/signaling"
+)
+
+func TestVerifyLimit(t *testing.T) {
+	config := DefaultConfig()
+	config.RateVerify, config.BurstVerify = 0.001, 2
+	s, err := newSignaling(config, signaling.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateRoom(&CreateRoom{Request: request("alice", "pw")}, nil); err != nil {
+		t.Fatal(err)
+	}
+	send := signaling.Message{Request: request("alice", "pw"), Event: signaling.New("", "", &signaling.Join{})}
+	tests := []struct {
+		name    string
+		act     func() error
+		limited bool
+	}{
+		{"wrong secret", func() error { return s.Join(request("eve", "guess"), nil) }, false},
+		{"wrong secret again", func() error { return s.Join(request("eve", "guess"), nil) }, false},
+		{"limited", func() error { return s.Join(request("eve", "guess"), nil) }, true},
+		{"right secret limited too", func() error { return s.Join(request("bob", "pw"), nil) }, true},
+		{"members are not", func() error { return s.Send(send, nil) }, false},
+	}
+	for _, tt := range tests {
+		err := tt.act()
+		if _, limited := signaling.AsRateLimitError(err); limited != tt.limited {
+			t.Errorf("%s: %v, want limited %v", tt.name, err, tt.limited)
+		}
+	}
+}
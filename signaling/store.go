@@ -0,0 +1,138 @@
+package signaling
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// RoomState is the persistent part of a Room.
+type RoomState struct {
+	Name      string
+	Owner     string
+	Preshared string // hash, see HashPreshared
+	Locked    bool
+	Knock     bool
+	Capacity  int
+	Public    bool
+	Title     string
+	Tags      []string
+	Kinds     []string `json:",omitempty"`
+	Bans      map[string]time.Time
+	Members   []string             `json:",omitempty"` // may rejoin a restored room, see RestoreRoom
+	Approved  map[string]time.Time `json:",omitempty"` // knocks approved until
+	Denied    map[string]time.Time `json:",omitempty"` // knocks denied until
+}
+
+// RoomStore ...
+type RoomStore interface {
+	Load() ([]*RoomState, error)
+	Save(state *RoomState) error
+	Delete(name string) error
+}
+
+// MemoryStore ...
+type MemoryStore struct {
+	mu     sync.Mutex
+	states map[string]*RoomState
+}
+
+// NewMemoryStore ...
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{states: map[string]*RoomState{}}
+}
+
+// Load ...
+func (s *MemoryStore) Load() ([]*RoomState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	states := []*RoomState{}
+	for _, state := range s.states {
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// Save ...
+func (s *MemoryStore) Save(state *RoomState) error {
+	s.mu.Lock()
+	s.states[state.Name] = state
+	s.mu.Unlock()
+	return nil
+}
+
+// Delete ...
+func (s *MemoryStore) Delete(name string) error {
+	s.mu.Lock()
+	delete(s.states, name)
+	s.mu.Unlock()
+	return nil
+}
+
+// FileStore keeps all room states in a single JSON file.
+type FileStore struct {
+	MemoryStore
+	path string
+}
+
+// NewFileStore ...
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{
+		MemoryStore: MemoryStore{states: map[string]*RoomState{}},
+		path:        path,
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.states); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Save ...
+func (s *FileStore) Save(state *RoomState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.states[state.Name] = state
+	return s.flush()
+}
+
+// Delete ...
+func (s *FileStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.states, name)
+	return s.flush()
+}
+
+func (s *FileStore) flush() error {
+	b, err := json.Marshal(s.states)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.path)
+}
@@ -0,0 +1,114 @@
+package signaling
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStores(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name   string
+		open   func() (RoomStore, error)
+		reopen bool // the states survive a new instance
+	}{
+		{"memory", func() (RoomStore, error) { return NewMemoryStore(), nil }, false},
+		{"file", func() (RoomStore, error) { return NewFileStore(filepath.Join(dir, "rooms.json")) }, true},
+	}
+	until := time.Now().Add(time.Hour).Round(0).UTC()
+	a := &RoomState{Name: "a", Owner: "alice", Locked: true, Members: []string{"alice", "bob"},
+		Bans: map[string]time.Time{"eve": until}, Denied: map[string]time.Time{"mallory": until}}
+	b := &RoomState{Name: "b", Owner: "bob", Bans: map[string]time.Time{}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := tt.open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, state := range []*RoomState{a, b} {
+				if err := store.Save(state); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := store.Delete("b"); err != nil {
+				t.Fatal(err)
+			}
+			if tt.reopen {
+				if store, err = tt.open(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			states, err := store.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(states) != 1 || !reflect.DeepEqual(states[0], a) {
+				t.Errorf("got %+v, want %+v", states, a)
+			}
+		})
+	}
+}
+
+func TestRestoreRoom(t *testing.T) {
+	room := newTestRoom(t)
+	hash := room.State().Preshared
+	state := &RoomState{
+		Name: "room", Owner: "owner", Preshared: hash, Locked: true,
+		Members: []string{"bob", "carol"},
+		Denied:  map[string]time.Time{"eve": time.Now().Add(time.Hour)},
+	}
+	tests := []struct {
+		user string
+		want error
+	}{
+		{"bob", nil},
+		{"dave", ErrLocked},
+		{"eve", ErrDenied},
+	}
+	restored := RestoreRoom(state)
+	checked := false
+	restored.SetCheckFunc(func() { checked = true })
+	defer restored.Close()
+	for _, tt := range tests {
+		if err := join(restored, tt.user); err != tt.want {
+			t.Errorf("%s: %v, want %v", tt.user, err, tt.want)
+		}
+	}
+	// carol did not come back yet, so she stays in the state.
+	if got := restored.State().Members; !reflect.DeepEqual(got, []string{"bob", "carol"}) {
+		t.Errorf("members %v", got)
+	}
+	if err := restored.Leave(Request{RoomID: "room", UserID: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if checked {
+		t.Errorf("a member's leave destroyed the room waiting for its owner")
+	}
+}
+
+func TestChangeFunc(t *testing.T) {
+	room := newTestRoom(t)
+	changes := 0
+	room.SetChangeFunc(func() { changes++ })
+	steps := []struct {
+		name string
+		act  func() error
+		want int
+	}{
+		{"join", func() error { return join(room, "bob") }, 1},
+		{"rejoin", func() error { return join(room, "bob") }, 1},
+		{"leave", func() error { return room.Leave(Request{RoomID: "room", UserID: "bob"}) }, 2},
+		{"join again", func() error { return join(room, "bob") }, 3},
+		{"kick", func() error { return room.Kick("bob", "") }, 4},
+	}
+	for _, s := range steps {
+		if err := s.act(); err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		if changes != s.want {
+			t.Errorf("%s: %d changes, want %d", s.name, changes, s.want)
+		}
+	}
+}
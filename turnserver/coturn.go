@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// CoturnConfig is what the wrapper tells coturn, see WriteFile.
+type CoturnConfig struct {
+	Realm       string
+	ListenPort  int
+	MinPort     int // relay port range
+	MaxPort     int
+	ExternalIPs []net.IP
+	AuthSecret  string   // TURN REST API shared secret, none means no auth
+	DeniedPeers []string // CIDRs or "from-to" ranges coturn never relays to
+}
+
+// Lines returns the turnserver.conf lines of c.
+func (c *CoturnConfig) Lines() ([]string, error) {
+	lines := []string{
+		"log-file=stdout",
+		"simple-log",
+		"no-cli",
+		"fingerprint",
+		fmt.Sprintf("realm=%s", c.Realm),
+		fmt.Sprintf("listening-port=%d", c.ListenPort),
+	}
+	if c.MinPort > 0 {
+		lines = append(lines,
+			fmt.Sprintf("min-port=%d", c.MinPort),
+			fmt.Sprintf("max-port=%d", c.MaxPort))
+	}
+	for _, ip := range c.ExternalIPs {
+		lines = append(lines, fmt.Sprintf("external-ip=%s", ip))
+	}
+	if len(c.AuthSecret) > 0 {
+		lines = append(lines, "use-auth-secret", fmt.Sprintf("static-auth-secret=%s", c.AuthSecret))
+	}
+	for _, peer := range c.DeniedPeers {
+		r, err := ipRange(peer)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, fmt.Sprintf("denied-peer-ip=%s", r))
+	}
+	return lines, nil
+}
+
+// WriteFile writes c to path, readable by the owner only as it holds
+// the auth secret.
+func (c *CoturnConfig) WriteFile(path string) error {
+	lines, err := c.Lines()
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "# generated by the p2pfw turnserver wrapper, do not edit.")
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Supervisor runs a command, restarting it with exponential backoff when
+// it exits, until Stop.
+type Supervisor struct {
+	Path       string
+	Args       []string
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	StableRun  time.Duration // a run this long resets the backoff
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	status   string
+	since    time.Time
+	restarts int
+	lastErr  string
+	stopping bool
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewSupervisor ...
+func NewSupervisor(path string, args ...string) *Supervisor {
+	return &Supervisor{
+		Path:       path,
+		Args:       args,
+		MinBackoff: time.Second,
+		MaxBackoff: time.Minute,
+		StableRun:  time.Minute,
+		status:     "starting",
+		since:      time.Now(),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+func (s *Supervisor) setStatus(status string, err error) {
+	s.mu.Lock()
+	s.status, s.since = status, time.Now()
+	if err != nil {
+		s.lastErr = err.Error()
+	}
+	s.mu.Unlock()
+}
+
+// Run blocks until Stop.
+func (s *Supervisor) Run() {
+	defer close(s.done)
+	backoff := s.MinBackoff
+	for {
+		cmd := exec.Command(s.Path, s.Args...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		s.mu.Lock()
+		if s.stopping {
+			s.mu.Unlock()
+			return
+		}
+		err := cmd.Start()
+		if err == nil {
+			s.cmd = cmd
+		}
+		s.mu.Unlock()
+		started := time.Now()
+		if err == nil {
+			s.setStatus("running", nil)
+			log.Printf("%s: started pid %d", s.Path, cmd.Process.Pid)
+			err = cmd.Wait()
+			if err == nil {
+				err = fmt.Errorf("exited")
+			}
+		}
+		s.mu.Lock()
+		s.cmd = nil
+		stopping := s.stopping
+		s.mu.Unlock()
+		if stopping {
+			s.setStatus("stopped", err)
+			return
+		}
+		if time.Since(started) >= s.StableRun {
+			backoff = s.MinBackoff
+		}
+		s.setStatus("restarting", err)
+		log.Printf("%s: %v, restart in %s", s.Path, err, backoff)
+		select {
+		case <-s.stop:
+			s.setStatus("stopped", nil)
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > s.MaxBackoff {
+			backoff = s.MaxBackoff
+		}
+		s.mu.Lock()
+		s.restarts++
+		s.mu.Unlock()
+	}
+}
+
+// Signal forwards sig to the running child.
+func (s *Supervisor) Signal(sig os.Signal) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cmd == nil {
+		return fmt.Errorf("%s: not running", s.Path)
+	}
+	return s.cmd.Process.Signal(sig)
+}
+
+// Stop forwards sig and waits for the child to exit, killing it after
+// timeout.
+func (s *Supervisor) Stop(sig os.Signal, timeout time.Duration) {
+	s.mu.Lock()
+	if !s.stopping {
+		s.stopping = true
+		close(s.stop)
+	}
+	cmd := s.cmd
+	s.mu.Unlock()
+	if cmd != nil {
+		cmd.Process.Signal(sig)
+	}
+	select {
+	case <-s.done:
+		return
+	case <-time.After(timeout):
+	}
+	s.mu.Lock()
+	if s.cmd != nil {
+		log.Printf("%s: killed after %s", s.Path, timeout)
+		s.cmd.Process.Kill()
+	}
+	s.mu.Unlock()
+	<-s.done
+}
+
+// Health ...
+type Health struct {
+	Status    string
+	PID       int `json:",omitempty"`
+	Since     time.Time
+	Restarts  int
+	LastError string `json:",omitempty"`
+}
+
+// Health reports the state of the child.
+func (s *Supervisor) Health() Health {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := Health{Status: s.status, Since: s.since, Restarts: s.restarts, LastError: s.lastErr}
+	if s.cmd != nil && s.cmd.Process != nil {
+		h.PID = s.cmd.Process.Pid
+	}
+	return h
+}
+
+// ServeHTTP answers 200 while the child runs, 503 otherwise.
+func (s *Supervisor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h := s.Health()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if h.Status != "running" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(h)
+}
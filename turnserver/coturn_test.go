@@ -0,0 +1,153 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIPRange(t *testing.T) {
+	for in, want := range map[string]string{
+		"10.0.0.0/8":        "10.0.0.0-10.255.255.255",
+		"192.168.1.0/24":    "192.168.1.0-192.168.1.255",
+		"fc00::/7":          "fc00::-fdff:ffff:ffff:ffff:ffff:ffff:ffff:ffff",
+		"127.0.0.1":         "127.0.0.1",
+		"::1":               "::1",
+		"10.0.0.1-10.0.0.9": "10.0.0.1-10.0.0.9",
+	} {
+		if got, err := ipRange(in); got != want || err != nil {
+			t.Errorf("%s: %q, %v, want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"10.0.0.1-nowhere", "10.0.0.0/33", "example.com"} {
+		if _, err := ipRange(in); err == nil {
+			t.Errorf("%s accepted", in)
+		}
+	}
+}
+
+func TestCoturnLines(t *testing.T) {
+	lines := func(c CoturnConfig) map[string]bool {
+		t.Helper()
+		lines, err := c.Lines()
+		if err != nil {
+			t.Fatal(err)
+		}
+		has := map[string]bool{}
+		for _, l := range lines {
+			has[l] = true
+		}
+		return has
+	}
+	has := lines(CoturnConfig{Realm: "p2pfw", ListenPort: 3478})
+	if !has["realm=p2pfw"] || !has["listening-port=3478"] || !has["fingerprint"] {
+		t.Errorf("minimal: %v", has)
+	}
+	if has["use-auth-secret"] || has["min-port=0"] {
+		t.Errorf("minimal: unset options in %v", has)
+	}
+
+	has = lines(CoturnConfig{
+		Realm: "p2pfw", ListenPort: 3479, MinPort: 49152, MaxPort: 65535,
+		ExternalIPs: []net.IP{net.ParseIP("203.0.113.1"), net.ParseIP("2001:db8::1")},
+		AuthSecret:  "s3cret",
+		DeniedPeers: []string{"10.0.0.0/8", "::1"},
+	})
+	for _, l := range []string{
+		"listening-port=3479", "min-port=49152", "max-port=65535",
+		"external-ip=203.0.113.1", "external-ip=2001:db8::1",
+		"use-auth-secret", "static-auth-secret=s3cret",
+		"denied-peer-ip=10.0.0.0-10.255.255.255", "denied-peer-ip=::1",
+	} {
+		if !has[l] {
+			t.Errorf("full: no %q", l)
+		}
+	}
+
+	if _, err := (&CoturnConfig{DeniedPeers: []string{"nowhere"}}).Lines(); err == nil {
+		t.Errorf("bad denied peer accepted")
+	}
+}
+
+func TestCoturnWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "turnserver.conf")
+	config := &CoturnConfig{Realm: "p2pfw", ListenPort: 3478, AuthSecret: "s3cret"}
+	if err := config.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("mode %v, the secret must stay private", fi.Mode())
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "static-auth-secret=s3cret\n") {
+		t.Errorf("got %q", b)
+	}
+}
+
+func TestSupervisor(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip(err)
+	}
+	health := func(s *Supervisor) (int, Health) {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+		return w.Code, s.Health()
+	}
+	wait := func(s *Supervisor, ok func(h Health) bool) Health {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if h := s.Health(); ok(h) {
+				return h
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("health %+v", s.Health())
+		return Health{}
+	}
+
+	// a crashing child is restarted with backoff.
+	crash := NewSupervisor(sh, "-c", "exit 1")
+	crash.MinBackoff, crash.MaxBackoff = time.Millisecond, 4*time.Millisecond
+	go crash.Run()
+	h := wait(crash, func(h Health) bool { return h.Restarts >= 3 })
+	if h.LastError == "" {
+		t.Errorf("no last error: %+v", h)
+	}
+	crash.Stop(syscall.SIGTERM, time.Second)
+	if code, h := health(crash); code != http.StatusServiceUnavailable || h.Status != "stopped" {
+		t.Errorf("stopped crash: %d %+v", code, h)
+	}
+
+	// a running child is healthy, and killed when it ignores the signal.
+	stubborn := NewSupervisor(sh, "-c", "trap '' TERM; while :; do sleep 0.01; done")
+	go stubborn.Run()
+	wait(stubborn, func(h Health) bool { return h.Status == "running" && h.PID > 0 })
+	if code, _ := health(stubborn); code != http.StatusOK {
+		t.Errorf("running: %d", code)
+	}
+	start := time.Now()
+	stubborn.Stop(syscall.SIGTERM, 100*time.Millisecond)
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Errorf("stopped after %s, before the timeout", d)
+	}
+	if _, h := health(stubborn); h.Status != "stopped" || h.PID != 0 {
+		t.Errorf("after stop: %+v", h)
+	}
+}
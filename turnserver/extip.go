@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DiscoveryTimeout bounds each attempt of an IPSource.
+const DiscoveryTimeout = 3 * time.Second
+
+// IPSource resolves an external address of this host, network is "ip4"
+// or "ip6".
+type IPSource interface {
+	ExternalIP(network string) (net.IP, error)
+	String() string
+}
+
+// ParseIPSource parses one of:
+//
+//	203.0.113.1 or 2001:db8::1   the address itself
+//	iface:eth0                   a global address of the interface
+//	stun:host:port               the mapped address from a STUN server
+//	http(s)://...                the body of an HTTP echo endpoint
+func ParseIPSource(spec string) (IPSource, error) {
+	switch {
+	case net.ParseIP(spec) != nil:
+		return staticIP{net.ParseIP(spec)}, nil
+	case strings.HasPrefix(spec, "iface:"):
+		return ifaceIP(strings.TrimPrefix(spec, "iface:")), nil
+	case strings.HasPrefix(spec, "stun:"):
+		addr := strings.TrimPrefix(spec, "stun:")
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("invalid ip source: %q: %v", spec, err)
+		}
+		return stunIP(addr), nil
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		return httpIP(spec), nil
+	}
+	return nil, fmt.Errorf("invalid ip source: %q (want ip, iface:, stun: or http(s)://)", spec)
+}
+
+// ParseIPSources parses a comma separated list of ParseIPSource.
+func ParseIPSources(specs string) ([]IPSource, error) {
+	sources := []IPSource{}
+	for _, spec := range strings.Split(specs, ",") {
+		if spec = strings.TrimSpace(spec); len(spec) == 0 {
+			continue
+		}
+		s, err := ParseIPSource(spec)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, s)
+	}
+	return sources, nil
+}
+
+// ResolveIP returns the address of the first source which answers one of
+// network, later sources are fallbacks.
+func ResolveIP(sources []IPSource, network string) (net.IP, error) {
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no ip source for %s", network)
+	}
+	for _, s := range sources {
+		ip, err := s.ExternalIP(network)
+		if err == nil && !matchFamily(ip, network) {
+			err = fmt.Errorf("not an %s address: %s", network, ip)
+		}
+		if err != nil {
+			log.Printf("external ip: %s: %v", s, err)
+			continue
+		}
+		log.Printf("external ip: %s: %s", s, ip)
+		return ip, nil
+	}
+	return nil, fmt.Errorf("no %s address from %d sources", network, len(sources))
+}
+
+func matchFamily(ip net.IP, network string) bool {
+	if ip == nil {
+		return false
+	}
+	if network == "ip4" {
+		return ip.To4() != nil
+	}
+	return ip.To4() == nil && ip.To16() != nil
+}
+
+type staticIP struct{ ip net.IP }
+
+func (s staticIP) ExternalIP(network string) (net.IP, error) { return s.ip, nil }
+func (s staticIP) String() string                            { return s.ip.String() }
+
+type ifaceIP string
+
+func (s ifaceIP) ExternalIP(network string) (net.IP, error) {
+	iface, err := net.InterfaceByName(string(s))
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if ok && ipnet.IP.IsGlobalUnicast() && matchFamily(ipnet.IP, network) {
+			return ipnet.IP, nil
+		}
+	}
+	return nil, fmt.Errorf("no %s address on %s", network, string(s))
+}
+
+func (s ifaceIP) String() string { return "iface:" + string(s) }
+
+type stunIP string
+
+// ExternalIP sends Binding requests until one is answered.
+func (s stunIP) ExternalIP(network string) (net.IP, error) {
+	udp := "udp4"
+	if network == "ip6" {
+		udp = "udp6"
+	}
+	conn, err := net.DialTimeout(udp, string(s), DiscoveryTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	req := &message{typ: messageType(methodBinding, classRequest), txID: newTxID()}
+	b := req.bytes(nil)
+	buf := make([]byte, 1500)
+	deadline := time.Now().Add(DiscoveryTimeout)
+	for wait := 250 * time.Millisecond; time.Now().Before(deadline); wait *= 2 {
+		if _, err := conn.Write(b); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(wait))
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return nil, err
+		}
+		res, err := parseMessage(buf[:n])
+		if err != nil || res.txID != req.txID || res.class() != classSuccess {
+			continue
+		}
+		if v, ok := res.get(attrXORMappedAddress); ok {
+			ip, _, err := parseXORAddress(v, res.txID)
+			return ip, err
+		}
+		if v, ok := res.get(attrMappedAddress); ok && len(v) >= 8 {
+			return net.IP(v[4:]), nil
+		}
+		return nil, fmt.Errorf("no mapped address")
+	}
+	return nil, fmt.Errorf("no answer")
+}
+
+func (s stunIP) String() string { return "stun:" + string(s) }
+
+type httpIP string
+
+// ExternalIP reads the address from an echo endpoint reached over network.
+func (s httpIP) ExternalIP(network string) (net.IP, error) {
+	tcp := "tcp4"
+	if network == "ip6" {
+		tcp = "tcp6"
+	}
+	dialer := &net.Dialer{Timeout: DiscoveryTimeout}
+	client := &http.Client{
+		Timeout: DiscoveryTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, tcp, addr)
+			},
+		},
+	}
+	resp, err := client.Get(string(s))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(strings.TrimSpace(string(b)))
+	if ip == nil {
+		return nil, fmt.Errorf("not an address: %q", b)
+	}
+	return ip, nil
+}
+
+func (s httpIP) String() string { return string(s) }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseIPSources(t *testing.T) {
+	specs := " 203.0.113.1 , 2001:db8::1,iface:eth0,,stun:stun.example.com:3478,https://api.ipify.org/"
+	sources, err := ParseIPSources(specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range sources {
+		got = append(got, s.String())
+	}
+	want := "203.0.113.1 2001:db8::1 iface:eth0 stun:stun.example.com:3478 https://api.ipify.org/"
+	if strings.Join(got, " ") != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if sources, err := ParseIPSources(""); err != nil || len(sources) != 0 {
+		t.Errorf("empty: %v, %v", sources, err)
+	}
+	for _, bad := range []string{"stun:stun.example.com", "example.com", "203.0.113.1,ftp://example.com"} {
+		if _, err := ParseIPSources(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+// failing is an IPSource which never answers.
+type failing struct{}
+
+func (failing) ExternalIP(network string) (net.IP, error) { return nil, fmt.Errorf("down") }
+func (failing) String() string                            { return "failing" }
+
+func TestResolveIP(t *testing.T) {
+	v4 := staticIP{net.ParseIP("203.0.113.1")}
+	v6 := staticIP{net.ParseIP("2001:db8::1")}
+	resolve := func(network string, sources ...IPSource) string {
+		t.Helper()
+		ip, err := ResolveIP(sources, network)
+		if err != nil {
+			return ""
+		}
+		return ip.String()
+	}
+	if got := resolve("ip4", failing{}, v4); got != "203.0.113.1" {
+		t.Errorf("fallback: %q", got)
+	}
+	if got := resolve("ip6", v4, v6); got != "2001:db8::1" {
+		t.Errorf("ip6 after an ip4 source: %q", got)
+	}
+	if got := resolve("ip6", failing{}, v4); got != "" {
+		t.Errorf("no ip6 source: %q", got)
+	}
+	if got := resolve("ip4"); got != "" {
+		t.Errorf("no source: %q", got)
+	}
+
+	// the remote sources, against local servers.
+	echo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "198.51.100.7")
+	}))
+	defer echo.Close()
+	if got := resolve("ip4", httpIP(echo.URL)); got != "198.51.100.7" {
+		t.Errorf("http echo: %q", got)
+	}
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	go ServeSTUNPacket(pc, nil)
+	if got := resolve("ip4", stunIP(pc.LocalAddr().String())); got != "127.0.0.1" {
+		t.Errorf("stun: %q", got)
+	}
+}
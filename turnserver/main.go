@@ -1,34 +1,220 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"os"
-	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/rs/cors"
 )
 
-func getIP() (string, error) {
-	resp, err := http.Get("https://api.ipify.org/")
+// externalIPs resolves the IPv4 and IPv6 relay addresses from their
+// sources, either may fail but not both.
+func externalIPs(specs4, specs6 string) (net.IP, net.IP, error) {
+	sources4, err := ParseIPSources(specs4)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
-	defer resp.Body.Close()
-	b, err := ioutil.ReadAll(resp.Body)
+	sources6, err := ParseIPSources(specs6)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
-	return string(b), nil
+	var ip4, ip6 net.IP
+	if len(sources4) > 0 {
+		if ip4, err = ResolveIP(sources4, "ip4"); err != nil {
+			log.Println(err)
+		}
+	}
+	if len(sources6) > 0 {
+		if ip6, err = ResolveIP(sources6, "ip6"); err != nil {
+			log.Println(err)
+		}
+	}
+	if ip4 == nil && ip6 == nil {
+		return nil, nil, fmt.Errorf("no external ip, see -external-ip and -external-ip6")
+	}
+	return ip4, ip6, nil
 }
 
-func main() {
-	ip, err := getIP()
+// portRange parses "min-max", 0-0 is ephemeral.
+func portRange(s string) (int, int, error) {
+	var min, max int
+	if _, err := fmt.Sscanf(s, "%d-%d", &min, &max); err != nil ||
+		min < 0 || max > 65535 || min > max {
+		return 0, 0, fmt.Errorf("invalid relay-ports: %q", s)
+	}
+	return min, max, nil
+}
+
+func newRelay(realm, secret string, ip4, ip6 net.IP, relayPorts, users string) (*Relay, error) {
+	r := NewRelay(realm, ip4, ip6)
+	r.Secret = secret
+	var err error
+	if r.PortMin, r.PortMax, err = portRange(relayPorts); err != nil {
+		return nil, err
+	}
+	for _, u := range strings.Split(users, ",") {
+		if u = strings.TrimSpace(u); len(u) == 0 {
+			continue
+		}
+		kv := strings.SplitN(u, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid user: %q (want name:password)", u)
+		}
+		r.Users[kv[0]] = kv[1]
+	}
+	if len(r.Users) == 0 && len(r.Secret) == 0 {
+		return nil, fmt.Errorf("-relay needs -users or -auth-secret")
+	}
+	return r, nil
+}
+
+// superviseCoturn writes the coturn configuration and starts coturn
+// under a Supervisor.
+func superviseCoturn(bin, path string, config *CoturnConfig) (*Supervisor, error) {
+	if len(config.AuthSecret) == 0 {
+		log.Println("no auth-secret: coturn runs without authentication")
+	}
+	if err := config.WriteFile(path); err != nil {
+		return nil, err
+	}
+	sup := NewSupervisor(bin, "-c", path)
+	go sup.Run()
+	return sup, nil
+}
+
+// portConflict fails when the -stun address takes the coturn port.
+func portConflict(stunAddr string, coturnPort int) error {
+	_, port, err := net.SplitHostPort(stunAddr)
 	if err != nil {
-		log.Fatalln("getip failed:", err)
+		return err
+	}
+	p, err := net.LookupPort("udp", port)
+	if err != nil {
+		return err
+	}
+	if p == coturnPort {
+		return fmt.Errorf("-stun %s collides with -coturn-port %d", stunAddr, coturnPort)
+	}
+	return nil
+}
+
+func main() {
+	secret := os.Getenv("TURN_SECRET")
+	realm := "p2pfw"
+	stunAddr := ""
+	coturn := true
+	relay := false
+	externalIP := "https://api.ipify.org/"
+	externalIP6 := ""
+	relayPorts := "49152-65535"
+	users := ""
+	maxAllocs := 0
+	userQuota := 10
+	maxLifetime := time.Hour
+	allowLoopback := false
+	coturnBin := "turnserver"
+	coturnConf := filepath.Join(os.TempDir(), "turnserver.conf")
+	coturnPort := 3478
+	deniedPeers := ""
+	flag.StringVar(&secret, "auth-secret", secret,
+		"TURN REST API shared secret, turn_secret of the signaling server (env TURN_SECRET)")
+	flag.StringVar(&realm, "realm", realm, "TURN realm")
+	flag.StringVar(&stunAddr, "stun", stunAddr, "serve STUN Binding on this UDP and TCP address (e.g. :3478)")
+	flag.BoolVar(&coturn, "coturn", coturn, "run coturn, off by default with -stun")
+	flag.BoolVar(&relay, "relay", relay, "run the embedded TURN relay on the -stun UDP address instead of coturn")
+	flag.StringVar(&externalIP, "external-ip", externalIP,
+		"IPv4 relay address sources tried in order: ip, iface:name, stun:host:port or http(s) echo URL")
+	flag.StringVar(&externalIP6, "external-ip6", externalIP6, "IPv6 relay address sources, as -external-ip")
+	flag.StringVar(&relayPorts, "relay-ports", relayPorts, "relayed port range min-max, 0-0 is ephemeral")
+	flag.StringVar(&users, "users", users, "long-term credentials name:password,...")
+	flag.IntVar(&maxAllocs, "max-allocations", maxAllocs, "allocations in total, 0 is unlimited")
+	flag.IntVar(&userQuota, "user-quota", userQuota, "allocations per username, 0 is unlimited")
+	flag.DurationVar(&maxLifetime, "max-lifetime", maxLifetime, "max allocation lifetime")
+	flag.BoolVar(&allowLoopback, "allow-loopback", allowLoopback, "relay to loopback peers (testing)")
+	flag.StringVar(&coturnBin, "coturn-bin", coturnBin, "coturn executable")
+	flag.StringVar(&coturnConf, "coturn-conf", coturnConf, "generated coturn configuration file")
+	flag.IntVar(&coturnPort, "coturn-port", coturnPort, "coturn listening port")
+	flag.StringVar(&deniedPeers, "denied-peers", deniedPeers,
+		"peer CIDRs or from-to ranges never relayed to, besides private, link-local and loopback (without -allow-loopback) ones")
+	flag.Parse()
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if coturn && len(stunAddr) > 0 {
+		if !set["coturn"] {
+			coturn = false
+		} else if err := portConflict(stunAddr, coturnPort); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if relay {
+		coturn = false
+		if len(stunAddr) == 0 {
+			log.Fatalln("-relay needs -stun")
+		}
+	}
+	if !coturn && len(stunAddr) == 0 {
+		log.Fatalln("nothing to serve: set -stun or -coturn")
+	}
+	denied := []string{}
+	for _, peer := range strings.Split(deniedPeers, ",") {
+		if peer = strings.TrimSpace(peer); len(peer) > 0 {
+			denied = append(denied, peer)
+		}
+	}
+	var ip4, ip6 net.IP
+	if relay || coturn {
+		var err error
+		if ip4, ip6, err = externalIPs(externalIP, externalIP6); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	var turn *Relay
+	if relay {
+		var err error
+		if turn, err = newRelay(realm, secret, ip4, ip6, relayPorts, users); err != nil {
+			log.Fatalln(err)
+		}
+		turn.MaxAllocations = maxAllocs
+		turn.UserQuota = userQuota
+		turn.MaxLifetime = maxLifetime
+		turn.AllowLoopback = allowLoopback
+		if err := turn.DenyPeers(denied...); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	var sup *Supervisor
+	if coturn {
+		config := &CoturnConfig{
+			Realm:      realm,
+			ListenPort: coturnPort,
+			AuthSecret: secret,
+		}
+		var err error
+		if config.MinPort, config.MaxPort, err = portRange(relayPorts); err != nil {
+			log.Fatalln(err)
+		}
+		for _, ip := range []net.IP{ip4, ip6} {
+			if ip != nil {
+				config.ExternalIPs = append(config.ExternalIPs, ip)
+			}
+		}
+		config.DeniedPeers = append(append(config.DeniedPeers, DefaultDeniedPeers...), denied...)
+		if !allowLoopback {
+			config.DeniedPeers = append(config.DeniedPeers, "127.0.0.0/8", "::1")
+		}
+		if sup, err = superviseCoturn(coturnBin, coturnConf, config); err != nil {
+			log.Fatalln(err)
+		}
+		http.Handle("/healthz", sup)
 	}
 	l, err := net.Listen("tcp", "0.0.0.0:8080")
 	if err != nil {
@@ -44,17 +230,50 @@ func main() {
 		fmt.Fprint(w, remote)
 	})))
 	log.Println("signaling server:", l.Addr())
+	errs := make(chan error, 3)
 	go func() {
-		if err := http.Serve(l, nil); err != nil {
+		errs <- http.Serve(l, nil)
+	}()
+	if len(stunAddr) > 0 {
+		pc, err := net.ListenPacket("udp", stunAddr)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		sl, err := net.Listen("tcp", stunAddr)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		log.Println("stun server:", pc.LocalAddr(), sl.Addr())
+		if turn != nil {
+			log.Println("turn relay:", pc.LocalAddr(), "relay ip:", turn.RelayIP, turn.RelayIP6)
+		}
+		go func() {
+			errs <- ServeSTUNPacket(pc, turn)
+		}()
+		go func() {
+			errs <- ServeSTUNStream(sl)
+		}()
+	}
+	if sup == nil {
+		log.Fatalln(<-errs)
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for {
+		select {
+		case sig := <-sigs:
+			if sig == syscall.SIGHUP {
+				if err := sup.Signal(sig); err != nil {
+					log.Println(err)
+				}
+				continue
+			}
+			log.Println("shutdown:", sig)
+			sup.Stop(sig, 10*time.Second)
+			return
+		case err := <-errs:
+			sup.Stop(syscall.SIGTERM, 10*time.Second)
 			log.Fatalln(err)
 		}
-	}()
-	cmd := exec.Command(
-		"turnserver", "-n", "--log-file=stdout",
-		fmt.Sprintf("--external-ip=%s", ip))
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		log.Fatalln(err)
 	}
 }
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"net"
+	"time"
+)
+
+// STUN message types and attributes (RFC 5389).
+const (
+	magicCookie = 0x2112A442
+	headerSize  = 20
+
+	classRequest = 0x000
+	classSuccess = 0x100
+	classError   = 0x110
+
+	methodBinding = 0x001
+
+	attrMappedAddress     = 0x0001
+	attrUsername          = 0x0006
+	attrMessageIntegrity  = 0x0008
+	attrErrorCode         = 0x0009
+	attrUnknownAttributes = 0x000A
+	attrRealm             = 0x0014
+	attrNonce             = 0x0015
+	attrXORMappedAddress  = 0x0020
+	attrSoftware          = 0x8022
+	attrFingerprint       = 0x8028
+
+	fingerprintXOR = 0x5354554e
+)
+
+// Software is sent in the SOFTWARE attribute of responses.
+const Software = "p2pfw turnserver"
+
+// StreamIdleTimeout closes idle STUN over TCP connections.
+const StreamIdleTimeout = time.Minute
+
+var errNotSTUN = errors.New("not a stun message")
+
+type attr struct {
+	typ   uint16
+	value []byte
+	off   int // of the attribute header in message.raw
+}
+
+type message struct {
+	typ   uint16
+	txID  [12]byte
+	attrs []attr
+	raw   []byte
+}
+
+func (m *message) method() uint16 {
+	return m.typ&0x000F | m.typ&0x00E0>>1 | m.typ&0x3E00>>2
+}
+
+func (m *message) class() uint16 {
+	return m.typ & 0x0110
+}
+
+func messageType(method, class uint16) uint16 {
+	return method&0x000F | method&0x0070<<1 | method&0x0F80<<2 | class
+}
+
+func parseMessage(b []byte) (*message, error) {
+	if len(b) < headerSize || b[0]&0xC0 != 0 ||
+		binary.BigEndian.Uint32(b[4:8]) != magicCookie {
+		return nil, errNotSTUN
+	}
+	n := int(binary.BigEndian.Uint16(b[2:4]))
+	if n%4 != 0 || len(b) < headerSize+n {
+		return nil, fmt.Errorf("bad stun length: %d", n)
+	}
+	m := &message{typ: binary.BigEndian.Uint16(b[0:2]), raw: b[:headerSize+n]}
+	copy(m.txID[:], b[8:20])
+	for p := b[headerSize : headerSize+n]; len(p) > 0; {
+		if len(p) < 4 {
+			return nil, fmt.Errorf("bad stun attribute")
+		}
+		typ := binary.BigEndian.Uint16(p[0:2])
+		l := int(binary.BigEndian.Uint16(p[2:4]))
+		padded := (l + 3) &^ 3
+		if len(p) < 4+padded {
+			return nil, fmt.Errorf("bad stun attribute length: %d", l)
+		}
+		m.attrs = append(m.attrs, attr{typ, p[4 : 4+l], len(m.raw) - len(p)})
+		p = p[4+padded:]
+	}
+	return m, nil
+}
+
+func (m *message) get(typ uint16) ([]byte, bool) {
+	for _, a := range m.attrs {
+		if a.typ == typ {
+			return a.value, true
+		}
+	}
+	return nil, false
+}
+
+func (m *message) getAll(typ uint16) [][]byte {
+	values := [][]byte{}
+	for _, a := range m.attrs {
+		if a.typ == typ {
+			values = append(values, a.value)
+		}
+	}
+	return values
+}
+
+func (m *message) add(typ uint16, value []byte) {
+	m.attrs = append(m.attrs, attr{typ: typ, value: value})
+}
+
+func (m *message) encode() []byte {
+	b := make([]byte, headerSize, 128)
+	binary.BigEndian.PutUint16(b[0:2], m.typ)
+	binary.BigEndian.PutUint32(b[4:8], magicCookie)
+	copy(b[8:20], m.txID[:])
+	for _, a := range m.attrs {
+		var h [4]byte
+		binary.BigEndian.PutUint16(h[0:2], a.typ)
+		binary.BigEndian.PutUint16(h[2:4], uint16(len(a.value)))
+		b = append(b, h[:]...)
+		b = append(b, a.value...)
+		for len(b)%4 != 0 {
+			b = append(b, 0)
+		}
+	}
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)-headerSize))
+	return b
+}
+
+// bytes encodes m followed by MESSAGE-INTEGRITY under key, if not nil,
+// and FINGERPRINT.
+func (m *message) bytes(key []byte) []byte {
+	if key != nil {
+		m.add(attrMessageIntegrity, make([]byte, sha1.Size))
+	}
+	m.add(attrFingerprint, make([]byte, 4))
+	b := m.encode()
+	if key != nil {
+		// the length covers the message up to MESSAGE-INTEGRITY.
+		binary.BigEndian.PutUint16(b[2:4], uint16(len(b)-8-headerSize))
+		mac := hmac.New(sha1.New, key)
+		mac.Write(b[:len(b)-8-24])
+		copy(b[len(b)-8-20:], mac.Sum(nil))
+		binary.BigEndian.PutUint16(b[2:4], uint16(len(b)-headerSize))
+	}
+	crc := crc32.ChecksumIEEE(b[:len(b)-8]) ^ fingerprintXOR
+	binary.BigEndian.PutUint32(b[len(b)-4:], crc)
+	return b
+}
+
+// checkIntegrity verifies the MESSAGE-INTEGRITY of a decoded m under key.
+func (m *message) checkIntegrity(key []byte) bool {
+	for _, a := range m.attrs {
+		if a.typ != attrMessageIntegrity {
+			continue
+		}
+		b := make([]byte, a.off)
+		copy(b, m.raw)
+		binary.BigEndian.PutUint16(b[2:4], uint16(a.off+24-headerSize))
+		mac := hmac.New(sha1.New, key)
+		mac.Write(b)
+		return hmac.Equal(mac.Sum(nil), a.value)
+	}
+	return false
+}
+
+// xorAddress encodes addr as (XOR-)MAPPED-ADDRESS.
+func xorAddress(addr net.Addr, txID [12]byte, xor bool) []byte {
+	var ip net.IP
+	var port int
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ip, port = a.IP, a.Port
+	case *net.TCPAddr:
+		ip, port = a.IP, a.Port
+	default:
+		return nil
+	}
+	family, raw := byte(0x01), ip.To4()
+	if raw == nil {
+		family, raw = 0x02, ip.To16()
+	}
+	v := make([]byte, 4+len(raw))
+	v[1] = family
+	binary.BigEndian.PutUint16(v[2:4], uint16(port))
+	copy(v[4:], raw)
+	if xor {
+		var key [16]byte
+		binary.BigEndian.PutUint32(key[0:4], magicCookie)
+		copy(key[4:], txID[:])
+		v[2] ^= key[0]
+		v[3] ^= key[1]
+		for i := range raw {
+			v[4+i] ^= key[i]
+		}
+	}
+	return v
+}
+
+// parseXORAddress decodes an XOR-*-ADDRESS attribute.
+func parseXORAddress(v []byte, txID [12]byte) (net.IP, int, error) {
+	if len(v) != 8 && len(v) != 20 {
+		return nil, 0, fmt.Errorf("bad address attribute")
+	}
+	var key [16]byte
+	binary.BigEndian.PutUint32(key[0:4], magicCookie)
+	copy(key[4:], txID[:])
+	port := int(binary.BigEndian.Uint16(v[2:4]) ^ binary.BigEndian.Uint16(key[0:2]))
+	ip := make(net.IP, len(v)-4)
+	for i := range ip {
+		ip[i] = v[4+i] ^ key[i]
+	}
+	return ip, port, nil
+}
+
+func newTxID() [12]byte {
+	var id [12]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		panic(err)
+	}
+	return id
+}
+
+func errorCode(code int, reason string) []byte {
+	v := make([]byte, 4, 4+len(reason))
+	v[2] = byte(code / 100)
+	v[3] = byte(code % 100)
+	return append(v, reason...)
+}
+
+// response starts the success or error response to req.
+func response(req *message, class uint16) *message {
+	res := &message{typ: messageType(req.method(), class), txID: req.txID}
+	res.add(attrSoftware, []byte(Software))
+	return res
+}
+
+// unknownAttributes returns the comprehension required attributes of m
+// which are not in known.
+func unknownAttributes(m *message, known ...uint16) []uint16 {
+	unknown := []uint16{}
+	for _, a := range m.attrs {
+		if a.typ >= 0x8000 {
+			continue
+		}
+		found := false
+		for _, k := range known {
+			if a.typ == k {
+				found = true
+				break
+			}
+		}
+		if !found {
+			unknown = append(unknown, a.typ)
+		}
+	}
+	return unknown
+}
+
+// bindingResponse answers a Binding request from addr, nil if req is not
+// one to answer.
+func bindingResponse(req *message, addr net.Addr) []byte {
+	if req.method() != methodBinding || req.class() != classRequest {
+		return nil
+	}
+	if unknown := unknownAttributes(req, attrFingerprint); len(unknown) > 0 {
+		res := response(req, classError)
+		res.add(attrErrorCode, errorCode(420, "Unknown Attribute"))
+		v := make([]byte, 2*len(unknown))
+		for i, typ := range unknown {
+			binary.BigEndian.PutUint16(v[2*i:], typ)
+		}
+		res.add(attrUnknownAttributes, v)
+		return res.bytes(nil)
+	}
+	res := response(req, classSuccess)
+	res.add(attrXORMappedAddress, xorAddress(addr, req.txID, true))
+	res.add(attrMappedAddress, xorAddress(addr, req.txID, false))
+	return res.bytes(nil)
+}
+
+// ServeSTUNPacket answers Binding requests on conn until it is closed,
+// and passes anything else to relay if not nil.
+func ServeSTUNPacket(conn net.PacketConn, relay *Relay) error {
+	buf := make([]byte, 64<<10)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		req, err := parseMessage(buf[:n])
+		if err == nil {
+			if res := bindingResponse(req, addr); res != nil {
+				if _, err := conn.WriteTo(res, addr); err != nil {
+					log.Println("stun:", err)
+				}
+				continue
+			}
+		}
+		if relay != nil {
+			relay.handle(conn, buf[:n], addr)
+		}
+	}
+}
+
+// ServeSTUNStream answers Binding requests on the connections accepted
+// from l, framed as in RFC 5389 section 7.2.2, until it is closed.
+func ServeSTUNStream(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go serveSTUNConn(conn)
+	}
+}
+
+func serveSTUNConn(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(StreamIdleTimeout))
+		head, err := r.Peek(headerSize)
+		if err != nil {
+			return
+		}
+		b := make([]byte, headerSize+int(binary.BigEndian.Uint16(head[2:4])))
+		if _, err := io.ReadFull(r, b); err != nil {
+			return
+		}
+		req, err := parseMessage(b)
+		if err != nil {
+			return
+		}
+		if res := bindingResponse(req, conn.RemoteAddr()); res != nil {
+			if _, err := conn.Write(res); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"net"
+	"strings"
+	"testing"
+)
+
+// checkFingerprint verifies the FINGERPRINT closing b.
+func checkFingerprint(t *testing.T, b []byte) {
+	t.Helper()
+	m, err := parseMessage(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := m.attrs[len(m.attrs)-1]
+	if last.typ != attrFingerprint {
+		t.Fatalf("last attribute %#x, want FINGERPRINT", last.typ)
+	}
+	if crc := crc32.ChecksumIEEE(b[:last.off]) ^ fingerprintXOR; binary.BigEndian.Uint32(last.value) != crc {
+		t.Errorf("fingerprint %x, want %08x", last.value, crc)
+	}
+}
+
+func bindingRequest(attrs ...attr) (*message, []byte) {
+	req := &message{typ: messageType(methodBinding, classRequest), txID: newTxID()}
+	req.attrs = attrs
+	return req, req.bytes(nil)
+}
+
+func TestXORAddress(t *testing.T) {
+	txID := newTxID()
+	tests := []net.Addr{
+		&net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 32853},
+		&net.TCPAddr{IP: net.ParseIP("2001:db8:1234:5678:11:2233:4455:6677"), Port: 3478},
+	}
+	for _, addr := range tests {
+		v := xorAddress(addr, txID, true)
+		ip, port, err := parseXORAddress(v, txID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := (&net.UDPAddr{IP: ip, Port: port}).String(); !strings.HasSuffix(addr.String(), got) {
+			t.Errorf("%s: got %s", addr, got)
+		}
+		if plain := xorAddress(addr, txID, false); bytes.Equal(plain, v) {
+			t.Errorf("%s: not xored", addr)
+		}
+	}
+}
+
+// rfc5769 is the sample request of RFC 5769 section 2.1.
+const rfc5769 = "000100582112a442b7e7a701bc34d686fa87dfae" +
+	"802200105354554e207465737420636c69656e74" +
+	"002400046e0001ff" +
+	"80290008932ff9b151263b36" +
+	"000600096576746a3a68367659202020" +
+	"000800149aeaa70cbfd8cb56781ef2b5b2d3f249c1b571a2" +
+	"80280004e57a3bcf"
+
+func TestMessageIntegrity(t *testing.T) {
+	sample, err := hex.DecodeString(rfc5769)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &message{typ: messageType(methodBinding, classRequest), txID: newTxID()}
+	req.add(attrUsername, []byte("user"))
+	signed := req.bytes([]byte("key"))
+	tampered := append([]byte{}, signed...)
+	tampered[headerSize+4] ^= 1
+	tests := []struct {
+		name string
+		b    []byte
+		key  string
+		ok   bool
+	}{
+		{"rfc 5769", sample, "VOkJxbRl1RmTxUk/WvJxBt", true},
+		{"rfc 5769 other key", sample, "password", false},
+		{"signed", signed, "key", true},
+		{"other key", signed, "yek", false},
+		{"tampered", tampered, "key", false},
+	}
+	for _, tt := range tests {
+		m, err := parseMessage(tt.b)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if ok := m.checkIntegrity([]byte(tt.key)); ok != tt.ok {
+			t.Errorf("%s: integrity %v, want %v", tt.name, ok, tt.ok)
+		}
+	}
+	checkFingerprint(t, sample)
+	checkFingerprint(t, signed)
+}
+
+func TestBinding(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	go ServeSTUNPacket(pc, nil)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go ServeSTUNStream(l)
+	tests := []struct {
+		name    string
+		network string
+		attrs   []attr
+		class   uint16
+	}{
+		{"udp", "udp", nil, classSuccess},
+		{"tcp", "tcp", nil, classSuccess},
+		{"optional attribute", "udp", []attr{{typ: attrSoftware, value: []byte("test")}}, classSuccess},
+		{"unknown attribute", "udp", []attr{{typ: 0x0024, value: []byte{0, 0, 0, 1}}}, classError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := pc.LocalAddr().String()
+			if tt.network == "tcp" {
+				addr = l.Addr().String()
+			}
+			conn, err := net.Dial(tt.network, addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			req, b := bindingRequest(tt.attrs...)
+			if _, err := conn.Write(b); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 1500)
+			n, err := conn.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := parseMessage(buf[:n])
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkFingerprint(t, buf[:n])
+			if res.txID != req.txID || res.method() != methodBinding || res.class() != tt.class {
+				t.Fatalf("response %#x to %x", res.typ, req.txID)
+			}
+			if tt.class == classError {
+				if v, _ := res.get(attrUnknownAttributes); !bytes.Equal(v, []byte{0x00, 0x24}) {
+					t.Errorf("unknown attributes %x", v)
+				}
+				return
+			}
+			v, ok := res.get(attrXORMappedAddress)
+			if !ok {
+				t.Fatal("no XOR-MAPPED-ADDRESS")
+			}
+			ip, port, err := parseXORAddress(v, res.txID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := (&net.UDPAddr{IP: ip, Port: port}).String(); got != conn.LocalAddr().String() {
+				t.Errorf("mapped %s, want %s", got, conn.LocalAddr())
+			}
+		})
+	}
+}
+
+func TestPortConflict(t *testing.T) {
+	tests := []struct {
+		stun string
+		port int
+		fail bool
+	}{
+		{":3478", 3478, true},
+		{"0.0.0.0:3478", 3478, true},
+		{":3479", 3478, false},
+		{"[::]:19302", 3478, false},
+		{"3478", 3478, true}, // not an address
+	}
+	for _, tt := range tests {
+		if err := portConflict(tt.stun, tt.port); (err != nil) != tt.fail {
+			t.Errorf("%s with %d: %v, want failure %v", tt.stun, tt.port, err, tt.fail)
+		}
+	}
+}
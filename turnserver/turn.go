@@ -0,0 +1,734 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TURN methods and attributes (RFC 5766, RFC 6156).
+const (
+	classIndication = 0x010
+
+	methodAllocate         = 0x003
+	methodRefresh          = 0x004
+	methodSend             = 0x006
+	methodData             = 0x007
+	methodCreatePermission = 0x008
+	methodChannelBind      = 0x009
+
+	attrChannelNumber      = 0x000C
+	attrLifetime           = 0x000D
+	attrXORPeerAddress     = 0x0012
+	attrData               = 0x0013
+	attrXORRelayedAddress  = 0x0016
+	attrRequestedFamily    = 0x0017
+	attrRequestedTransport = 0x0019
+
+	protoUDP = 17
+)
+
+// TURN timers (RFC 5766 section 2.2, 8 and 11).
+const (
+	DefaultLifetime    = 10 * time.Minute
+	PermissionLifetime = 5 * time.Minute
+	ChannelLifetime    = 10 * time.Minute
+	NonceLifetime      = 10 * time.Minute
+)
+
+// DefaultDeniedPeers are never relayed to unless allowed otherwise:
+// private (RFC 1918), link-local and unique local (RFC 4193) addresses.
+var DefaultDeniedPeers = []string{
+	"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "169.254.0.0/16",
+	"fc00::/7", "fe80::/10",
+}
+
+// ipRange converts a CIDR to an inclusive "from-to" range.
+func ipRange(s string) (string, error) {
+	if strings.Contains(s, "-") {
+		parts := strings.SplitN(s, "-", 2)
+		if net.ParseIP(parts[0]) == nil || net.ParseIP(parts[1]) == nil {
+			return "", fmt.Errorf("invalid ip range: %q", s)
+		}
+		return s, nil
+	}
+	if ip := net.ParseIP(s); ip != nil {
+		return s, nil
+	}
+	_, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		return "", err
+	}
+	first := ipnet.IP
+	last := make(net.IP, len(first))
+	for i := range first {
+		last[i] = first[i] | ^ipnet.Mask[i]
+	}
+	return first.String() + "-" + last.String(), nil
+}
+
+// peerRange is an inclusive range of peer addresses of one family.
+type peerRange struct {
+	from, to net.IP
+}
+
+// parsePeerRange parses a CIDR, an IP or a "from-to" range.
+func parsePeerRange(s string) (peerRange, error) {
+	r, err := ipRange(s)
+	if err != nil {
+		return peerRange{}, err
+	}
+	parts := strings.SplitN(r, "-", 2)
+	p := peerRange{net.ParseIP(parts[0]), net.ParseIP(parts[0])}
+	if len(parts) == 2 {
+		p.to = net.ParseIP(parts[1])
+	}
+	if (p.from.To4() == nil) != (p.to.To4() == nil) {
+		return peerRange{}, fmt.Errorf("invalid ip range: %q", s)
+	}
+	return p, nil
+}
+
+func (p peerRange) contains(ip net.IP) bool {
+	if (p.from.To4() == nil) != (ip.To4() == nil) {
+		return false
+	}
+	ip = ip.To16()
+	return bytes.Compare(ip, p.from.To16()) >= 0 && bytes.Compare(ip, p.to.To16()) <= 0
+}
+
+// Relay is an embedded TURN server: UDP allocations for clients reaching
+// it over UDP, authenticated with long-term credentials (Users) or TURN
+// REST API ones (Secret).
+type Relay struct {
+	Realm          string
+	Users          map[string]string // username: password
+	Secret         string            // TURN REST API shared secret
+	RelayIP        net.IP            // IPv4 advertised in XOR-RELAYED-ADDRESS, nil disables IPv4
+	RelayIP6       net.IP            // IPv6 advertised in XOR-RELAYED-ADDRESS, nil disables IPv6
+	BindIP         net.IP            // relayed sockets of its family listen on it, default any
+	PortMin        int               // relayed port range, 0 is ephemeral
+	PortMax        int
+	MaxLifetime    time.Duration
+	MaxAllocations int // in total, 0 is unlimited
+	UserQuota      int // per username, 0 is unlimited
+	AllowLoopback  bool
+
+	denied   []peerRange
+	nonceKey []byte
+	mu       sync.Mutex
+	allocs   map[string]*allocation // by client address
+	quota    map[string]int         // allocations by username
+	once     sync.Once
+}
+
+// NewRelay ...
+func NewRelay(realm string, relayIP, relayIP6 net.IP) *Relay {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	denied := []peerRange{}
+	for _, s := range DefaultDeniedPeers {
+		p, err := parsePeerRange(s)
+		if err != nil {
+			panic(err)
+		}
+		denied = append(denied, p)
+	}
+	return &Relay{
+		denied:      denied,
+		Realm:       realm,
+		Users:       map[string]string{},
+		RelayIP:     relayIP,
+		RelayIP6:    relayIP6,
+		MaxLifetime: time.Hour,
+		nonceKey:    key,
+		allocs:      map[string]*allocation{},
+		quota:       map[string]int{},
+	}
+}
+
+type channel struct {
+	peer    *net.UDPAddr
+	expires time.Time
+}
+
+type allocation struct {
+	relay    *Relay
+	conn     net.PacketConn // to the client
+	client   net.Addr
+	pc       net.PacketConn // relayed transport address
+	ip6      bool           // family of pc, peers must match
+	username string
+	key      []byte
+	txID     [12]byte // of the Allocate, to answer retransmissions
+	response []byte
+
+	mu       sync.Mutex
+	expires  time.Time
+	perms    map[string]time.Time // by peer IP
+	channels map[uint16]*channel
+	peers    map[string]uint16 // channel by peer address
+}
+
+// nonce is stateless: a timestamp and its MAC.
+func (r *Relay) nonce() []byte {
+	ts := strconv.FormatInt(time.Now().Unix(), 16)
+	mac := hmac.New(sha1.New, r.nonceKey)
+	mac.Write([]byte(ts))
+	return []byte(ts + "-" + hex.EncodeToString(mac.Sum(nil)))
+}
+
+func (r *Relay) validNonce(nonce []byte) bool {
+	parts := strings.SplitN(string(nonce), "-", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	mac := hmac.New(sha1.New, r.nonceKey)
+	mac.Write([]byte(parts[0]))
+	if !hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(parts[1])) {
+		return false
+	}
+	ts, err := strconv.ParseInt(parts[0], 16, 64)
+	return err == nil && time.Since(time.Unix(ts, 0)) < NonceLifetime
+}
+
+// password returns the password of username, TURN REST API usernames are
+// "expiry:user".
+func (r *Relay) password(username string) (string, bool) {
+	if p, ok := r.Users[username]; ok {
+		return p, true
+	}
+	if len(r.Secret) == 0 {
+		return "", false
+	}
+	ts, err := strconv.ParseInt(strings.SplitN(username, ":", 2)[0], 10, 64)
+	if err != nil || time.Now().Unix() > ts {
+		return "", false
+	}
+	mac := hmac.New(sha1.New, []byte(r.Secret))
+	mac.Write([]byte(username))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil)), true
+}
+
+// authenticate returns the long-term key of the request, or the error
+// response to send.
+func (r *Relay) authenticate(req *message) (string, []byte, []byte) {
+	unauthorized := func(code int, reason string) []byte {
+		res := response(req, classError)
+		res.add(attrErrorCode, errorCode(code, reason))
+		res.add(attrRealm, []byte(r.Realm))
+		res.add(attrNonce, r.nonce())
+		return res.bytes(nil)
+	}
+	if _, ok := req.get(attrMessageIntegrity); !ok {
+		return "", nil, unauthorized(401, "Unauthorized")
+	}
+	username, ok1 := req.get(attrUsername)
+	realm, ok2 := req.get(attrRealm)
+	nonce, ok3 := req.get(attrNonce)
+	if !ok1 || !ok2 || !ok3 {
+		res := response(req, classError)
+		res.add(attrErrorCode, errorCode(400, "Bad Request"))
+		return "", nil, res.bytes(nil)
+	}
+	if !r.validNonce(nonce) {
+		return "", nil, unauthorized(438, "Stale Nonce")
+	}
+	password, ok := r.password(string(username))
+	if !ok || string(realm) != r.Realm {
+		return "", nil, unauthorized(401, "Unauthorized")
+	}
+	sum := md5.Sum([]byte(string(username) + ":" + r.Realm + ":" + password))
+	if !req.checkIntegrity(sum[:]) {
+		return "", nil, unauthorized(401, "Unauthorized")
+	}
+	return string(username), sum[:], nil
+}
+
+func errorResponse(req *message, key []byte, code int, reason string) []byte {
+	res := response(req, classError)
+	res.add(attrErrorCode, errorCode(code, reason))
+	return res.bytes(key)
+}
+
+// DenyPeers adds CIDRs, IPs or "from-to" ranges never to relay to, on
+// top of DefaultDeniedPeers.
+func (r *Relay) DenyPeers(peers ...string) error {
+	for _, s := range peers {
+		p, err := parsePeerRange(s)
+		if err != nil {
+			return err
+		}
+		r.denied = append(r.denied, p)
+	}
+	return nil
+}
+
+// allowedPeer refuses to relay to addresses which are never a peer, to
+// the denied ones and to loopback unless AllowLoopback. ip is as decoded
+// from XOR-PEER-ADDRESS, so an IPv4-mapped IPv6 address is 16 bytes long.
+func (r *Relay) allowedPeer(ip net.IP) bool {
+	if ip.IsUnspecified() || ip.IsMulticast() || len(ip) == net.IPv6len && ip.To4() != nil {
+		return false
+	}
+	if ip.IsLoopback() {
+		return r.AllowLoopback
+	}
+	for _, p := range r.denied {
+		if p.contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Relay) start() {
+	r.once.Do(func() {
+		go func() {
+			for range time.Tick(10 * time.Second) {
+				r.expire()
+			}
+		}()
+	})
+}
+
+func (r *Relay) expire() {
+	now := time.Now()
+	r.mu.Lock()
+	expired := []*allocation{}
+	for _, a := range r.allocs {
+		a.mu.Lock()
+		if now.After(a.expires) {
+			expired = append(expired, a)
+		}
+		for ip, exp := range a.perms {
+			if now.After(exp) {
+				delete(a.perms, ip)
+			}
+		}
+		for num, ch := range a.channels {
+			if now.After(ch.expires) {
+				delete(a.channels, num)
+				delete(a.peers, ch.peer.String())
+			}
+		}
+		a.mu.Unlock()
+	}
+	r.mu.Unlock()
+	for _, a := range expired {
+		r.remove(a)
+	}
+}
+
+func (r *Relay) remove(a *allocation) {
+	r.mu.Lock()
+	if r.allocs[a.client.String()] == a {
+		delete(r.allocs, a.client.String())
+		if r.quota[a.username]--; r.quota[a.username] <= 0 {
+			delete(r.quota, a.username)
+		}
+	}
+	r.mu.Unlock()
+	a.pc.Close()
+}
+
+func (r *Relay) lookup(client net.Addr) *allocation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.allocs[client.String()]
+}
+
+// listenRelay opens a relayed transport address in the port range.
+func (r *Relay) listenRelay(ip6 bool) (net.PacketConn, error) {
+	network, family, bind := "udp4", "ip4", net.IPv4zero
+	if ip6 {
+		network, family, bind = "udp6", "ip6", net.IPv6unspecified
+	}
+	if r.BindIP != nil && matchFamily(r.BindIP, family) {
+		bind = r.BindIP
+	}
+	if r.PortMin == 0 {
+		return net.ListenUDP(network, &net.UDPAddr{IP: bind})
+	}
+	n := int64(r.PortMax - r.PortMin + 1)
+	for i := 0; i < 16; i++ {
+		off, err := rand.Int(rand.Reader, big.NewInt(n))
+		if err != nil {
+			return nil, err
+		}
+		pc, err := net.ListenUDP(network, &net.UDPAddr{IP: bind, Port: r.PortMin + int(off.Int64())})
+		if err == nil {
+			return pc, nil
+		}
+	}
+	return nil, fmt.Errorf("no free relay port in %d-%d", r.PortMin, r.PortMax)
+}
+
+// maxLifetime is MaxLifetime, DefaultLifetime if unset.
+func (r *Relay) maxLifetime() time.Duration {
+	if r.MaxLifetime > 0 {
+		return r.MaxLifetime
+	}
+	return DefaultLifetime
+}
+
+// defaultLifetime is DefaultLifetime, unless MaxLifetime is shorter.
+func (r *Relay) defaultLifetime() time.Duration {
+	if max := r.maxLifetime(); max < DefaultLifetime {
+		return max
+	}
+	return DefaultLifetime
+}
+
+// lifetime is the LIFETIME asked by req between the default and the max
+// lifetime, 0 is kept to delete the allocation.
+func (r *Relay) lifetime(req *message) time.Duration {
+	v, ok := req.get(attrLifetime)
+	if !ok || len(v) != 4 {
+		return r.defaultLifetime()
+	}
+	d := time.Duration(binary.BigEndian.Uint32(v)) * time.Second
+	switch {
+	case d == 0:
+	case d > r.maxLifetime():
+		d = r.maxLifetime()
+	case d < r.defaultLifetime():
+		d = r.defaultLifetime()
+	}
+	return d
+}
+
+func lifetimeAttr(d time.Duration) []byte {
+	v := make([]byte, 4)
+	binary.BigEndian.PutUint32(v, uint32(d/time.Second))
+	return v
+}
+
+// handle serves a TURN request, indication or ChannelData from client
+// on conn, Binding requests are answered before.
+func (r *Relay) handle(conn net.PacketConn, b []byte, client net.Addr) {
+	r.start()
+	req, err := parseMessage(b)
+	if err == errNotSTUN {
+		r.channelData(b, client)
+		return
+	}
+	if err != nil {
+		return
+	}
+	if req.class() == classIndication {
+		if req.method() == methodSend {
+			r.send(req, client)
+		}
+		return
+	}
+	if req.class() != classRequest {
+		return
+	}
+	reply := func(res []byte) {
+		if _, err := conn.WriteTo(res, client); err != nil {
+			log.Println("turn:", err)
+		}
+	}
+	switch req.method() {
+	case methodAllocate, methodRefresh, methodCreatePermission, methodChannelBind:
+	default:
+		reply(errorResponse(req, nil, 400, "Bad Request"))
+		return
+	}
+	if a := r.lookup(client); a != nil && req.method() == methodAllocate && a.txID == req.txID {
+		reply(a.response) // retransmission
+		return
+	}
+	username, key, res := r.authenticate(req)
+	if res != nil {
+		reply(res)
+		return
+	}
+	if unknown := unknownAttributes(req, attrUsername, attrRealm, attrNonce,
+		attrMessageIntegrity, attrLifetime, attrRequestedTransport, attrRequestedFamily,
+		attrXORPeerAddress, attrChannelNumber); len(unknown) > 0 {
+		res := response(req, classError)
+		res.add(attrErrorCode, errorCode(420, "Unknown Attribute"))
+		v := make([]byte, 2*len(unknown))
+		for i, typ := range unknown {
+			binary.BigEndian.PutUint16(v[2*i:], typ)
+		}
+		res.add(attrUnknownAttributes, v)
+		reply(res.bytes(key))
+		return
+	}
+	if req.method() == methodAllocate {
+		reply(r.allocate(conn, req, client, username, key))
+		return
+	}
+	a := r.lookup(client)
+	if a == nil {
+		reply(errorResponse(req, key, 437, "Allocation Mismatch"))
+		return
+	}
+	if a.username != username {
+		reply(errorResponse(req, key, 441, "Wrong Credentials"))
+		return
+	}
+	switch req.method() {
+	case methodRefresh:
+		reply(r.refresh(a, req))
+	case methodCreatePermission:
+		reply(r.createPermission(a, req))
+	case methodChannelBind:
+		reply(r.channelBind(a, req))
+	}
+}
+
+func (r *Relay) allocate(conn net.PacketConn, req *message, client net.Addr, username string, key []byte) []byte {
+	if r.lookup(client) != nil {
+		return errorResponse(req, key, 437, "Allocation Mismatch")
+	}
+	transport, ok := req.get(attrRequestedTransport)
+	if !ok || len(transport) != 4 {
+		return errorResponse(req, key, 400, "Bad Request")
+	}
+	if transport[0] != protoUDP {
+		return errorResponse(req, key, 442, "Unsupported Transport Protocol")
+	}
+	ip6, relayIP := false, r.RelayIP
+	if family, ok := req.get(attrRequestedFamily); ok {
+		if len(family) != 4 || (family[0] != 0x01 && family[0] != 0x02) {
+			return errorResponse(req, key, 400, "Bad Request")
+		}
+		if family[0] == 0x02 {
+			ip6, relayIP = true, r.RelayIP6
+		}
+	}
+	if relayIP == nil {
+		return errorResponse(req, key, 440, "Address Family not Supported")
+	}
+	r.mu.Lock()
+	if (r.MaxAllocations > 0 && len(r.allocs) >= r.MaxAllocations) ||
+		(r.UserQuota > 0 && r.quota[username] >= r.UserQuota) {
+		r.mu.Unlock()
+		return errorResponse(req, key, 486, "Allocation Quota Reached")
+	}
+	r.quota[username]++
+	r.mu.Unlock()
+	pc, err := r.listenRelay(ip6)
+	if err != nil {
+		log.Println("turn:", err)
+		r.mu.Lock()
+		r.quota[username]--
+		r.mu.Unlock()
+		return errorResponse(req, key, 508, "Insufficient Capacity")
+	}
+	lifetime := r.lifetime(req)
+	if lifetime == 0 {
+		lifetime = r.defaultLifetime()
+	}
+	a := &allocation{
+		relay:    r,
+		conn:     conn,
+		client:   client,
+		pc:       pc,
+		ip6:      ip6,
+		username: username,
+		key:      key,
+		txID:     req.txID,
+		expires:  time.Now().Add(lifetime),
+		perms:    map[string]time.Time{},
+		channels: map[uint16]*channel{},
+		peers:    map[string]uint16{},
+	}
+	relayed := &net.UDPAddr{IP: relayIP, Port: pc.LocalAddr().(*net.UDPAddr).Port}
+	res := response(req, classSuccess)
+	res.add(attrXORRelayedAddress, xorAddress(relayed, req.txID, true))
+	res.add(attrLifetime, lifetimeAttr(lifetime))
+	res.add(attrXORMappedAddress, xorAddress(client, req.txID, true))
+	a.response = res.bytes(key)
+	r.mu.Lock()
+	r.allocs[client.String()] = a
+	r.mu.Unlock()
+	log.Printf("turn: allocate %s for %s (%s)", relayed, client, username)
+	go a.relayLoop()
+	return a.response
+}
+
+func (r *Relay) refresh(a *allocation, req *message) []byte {
+	lifetime := r.lifetime(req)
+	if lifetime == 0 {
+		r.remove(a)
+	} else {
+		a.mu.Lock()
+		a.expires = time.Now().Add(lifetime)
+		a.mu.Unlock()
+	}
+	res := response(req, classSuccess)
+	res.add(attrLifetime, lifetimeAttr(lifetime))
+	return res.bytes(a.key)
+}
+
+// peerAddress decodes v, nil if it is malformed.
+func peerAddress(v []byte, txID [12]byte) *net.UDPAddr {
+	ip, port, err := parseXORAddress(v, txID)
+	if err != nil {
+		return nil
+	}
+	return &net.UDPAddr{IP: ip, Port: port}
+}
+
+func (r *Relay) createPermission(a *allocation, req *message) []byte {
+	values := req.getAll(attrXORPeerAddress)
+	if len(values) == 0 {
+		return errorResponse(req, a.key, 400, "Bad Request")
+	}
+	peers := []*net.UDPAddr{}
+	for _, v := range values {
+		peer := peerAddress(v, req.txID)
+		if peer == nil {
+			return errorResponse(req, a.key, 400, "Bad Request")
+		}
+		if a.ip6 == (peer.IP.To4() != nil) {
+			return errorResponse(req, a.key, 443, "Peer Address Family Mismatch")
+		}
+		if !r.allowedPeer(peer.IP) {
+			return errorResponse(req, a.key, 403, "Forbidden")
+		}
+		peers = append(peers, peer)
+	}
+	a.mu.Lock()
+	for _, peer := range peers {
+		a.perms[peer.IP.String()] = time.Now().Add(PermissionLifetime)
+	}
+	a.mu.Unlock()
+	return response(req, classSuccess).bytes(a.key)
+}
+
+func (r *Relay) channelBind(a *allocation, req *message) []byte {
+	num, ok := req.get(attrChannelNumber)
+	v, ok2 := req.get(attrXORPeerAddress)
+	if !ok || !ok2 || len(num) != 4 {
+		return errorResponse(req, a.key, 400, "Bad Request")
+	}
+	n := binary.BigEndian.Uint16(num)
+	peer := peerAddress(v, req.txID)
+	if n < 0x4000 || n > 0x7FFE || peer == nil {
+		return errorResponse(req, a.key, 400, "Bad Request")
+	}
+	if a.ip6 == (peer.IP.To4() != nil) {
+		return errorResponse(req, a.key, 443, "Peer Address Family Mismatch")
+	}
+	if !r.allowedPeer(peer.IP) {
+		return errorResponse(req, a.key, 403, "Forbidden")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if ch, ok := a.channels[n]; ok && ch.peer.String() != peer.String() {
+		return errorResponse(req, a.key, 400, "Bad Request")
+	}
+	if bound, ok := a.peers[peer.String()]; ok && bound != n {
+		return errorResponse(req, a.key, 400, "Bad Request")
+	}
+	now := time.Now()
+	a.channels[n] = &channel{peer, now.Add(ChannelLifetime)}
+	a.peers[peer.String()] = n
+	a.perms[peer.IP.String()] = now.Add(PermissionLifetime)
+	return response(req, classSuccess).bytes(a.key)
+}
+
+func (a *allocation) permitted(ip net.IP) bool {
+	exp, ok := a.perms[ip.String()]
+	return ok && time.Now().Before(exp)
+}
+
+// send relays a Send indication.
+func (r *Relay) send(req *message, client net.Addr) {
+	a := r.lookup(client)
+	if a == nil {
+		return
+	}
+	v, ok := req.get(attrXORPeerAddress)
+	data, ok2 := req.get(attrData)
+	if !ok || !ok2 {
+		return
+	}
+	peer := peerAddress(v, req.txID)
+	if peer == nil {
+		return
+	}
+	a.mu.Lock()
+	permitted := a.permitted(peer.IP)
+	a.mu.Unlock()
+	if permitted {
+		a.pc.WriteTo(data, peer)
+	}
+}
+
+// channelData relays a ChannelData message.
+func (r *Relay) channelData(b []byte, client net.Addr) {
+	if len(b) < 4 || b[0]&0xC0 != 0x40 {
+		return
+	}
+	a := r.lookup(client)
+	if a == nil {
+		return
+	}
+	n := binary.BigEndian.Uint16(b[0:2])
+	l := int(binary.BigEndian.Uint16(b[2:4]))
+	if len(b) < 4+l {
+		return
+	}
+	a.mu.Lock()
+	ch, ok := a.channels[n]
+	a.mu.Unlock()
+	if ok && time.Now().Before(ch.expires) {
+		a.pc.WriteTo(b[4:4+l], ch.peer)
+	}
+}
+
+// relayLoop forwards what peers send to the relayed address to the client.
+func (a *allocation) relayLoop() {
+	buf := make([]byte, 64<<10)
+	for {
+		n, from, err := a.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		peer := from.(*net.UDPAddr)
+		a.mu.Lock()
+		permitted := a.permitted(peer.IP)
+		num, bound := a.peers[peer.String()]
+		a.mu.Unlock()
+		if !permitted {
+			continue
+		}
+		var b []byte
+		if bound {
+			b = make([]byte, 4+n)
+			binary.BigEndian.PutUint16(b[0:2], num)
+			binary.BigEndian.PutUint16(b[2:4], uint16(n))
+			copy(b[4:], buf[:n])
+		} else {
+			ind := &message{typ: messageType(methodData, classIndication), txID: newTxID()}
+			ind.add(attrXORPeerAddress, xorAddress(peer, ind.txID, true))
+			ind.add(attrData, buf[:n])
+			b = ind.encode()
+		}
+		if _, err := a.conn.WriteTo(b, a.client); err != nil {
+			log.Println("turn:", err)
+		}
+	}
+}
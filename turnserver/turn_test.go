@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAllowedPeer(t *testing.T) {
+	r := NewRelay("test", nil, nil)
+	if err := r.DenyPeers("203.0.113.0/24", "198.51.100.10-198.51.100.20"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ip       string
+		loopback bool
+		want     bool
+	}{
+		{"8.8.8.8", false, true},
+		{"2001:4860:4860::8888", false, true},
+		{"10.1.2.3", false, false},
+		{"172.16.0.1", false, false},
+		{"172.32.0.1", false, true},
+		{"192.168.1.1", true, false},
+		{"169.254.169.254", false, false},
+		{"fd00::1", false, false},
+		{"fe80::1", false, false},
+		{"::ffff:8.8.8.8", false, false},
+		{"127.0.0.1", false, false},
+		{"127.0.0.1", true, true},
+		{"::1", true, true},
+		{"0.0.0.0", true, false},
+		{"224.0.0.1", false, false},
+		{"203.0.113.7", false, false},
+		{"198.51.100.15", false, false},
+		{"198.51.100.21", false, true},
+	}
+	for _, tt := range tests {
+		ip := net.ParseIP(tt.ip)
+		if ip4 := ip.To4(); ip4 != nil && tt.ip[0] != ':' {
+			ip = ip4 // as decoded from XOR-PEER-ADDRESS
+		}
+		r.AllowLoopback = tt.loopback
+		if got := r.allowedPeer(ip); got != tt.want {
+			t.Errorf("%s (loopback %v): %v, want %v", tt.ip, tt.loopback, got, tt.want)
+		}
+	}
+	if err := r.DenyPeers("10.0.0.1-::1"); err == nil {
+		t.Errorf("mixed families accepted")
+	}
+}
+
+func TestLifetime(t *testing.T) {
+	tests := []struct {
+		max  time.Duration
+		ask  int // seconds, -1 for no LIFETIME
+		want time.Duration
+	}{
+		{time.Hour, -1, DefaultLifetime},
+		{time.Hour, 60, DefaultLifetime},
+		{time.Hour, 1800, 30 * time.Minute},
+		{time.Hour, 7200, time.Hour},
+		{time.Hour, 0, 0},
+		{5 * time.Minute, -1, 5 * time.Minute},
+		{5 * time.Minute, 60, 5 * time.Minute},
+		{5 * time.Minute, 1800, 5 * time.Minute},
+		{5 * time.Minute, 0, 0},
+		{0, 1800, DefaultLifetime},
+	}
+	for _, tt := range tests {
+		r := NewRelay("test", nil, nil)
+		r.MaxLifetime = tt.max
+		req := newRequest(methodRefresh)
+		if tt.ask >= 0 {
+			req.add(attrLifetime, lifetimeAttr(time.Duration(tt.ask)*time.Second))
+		}
+		if got := r.lifetime(req); got != tt.want {
+			t.Errorf("max %s, ask %ds: %s, want %s", tt.max, tt.ask, got, tt.want)
+		}
+	}
+}
+
+// turnClient talks to a Relay as user "u" with password "p".
+type turnClient struct {
+	t     *testing.T
+	conn  net.Conn
+	nonce []byte
+	key   []byte
+}
+
+func newRequest(method uint16) *message {
+	return &message{typ: messageType(method, classRequest), txID: newTxID()}
+}
+
+// do sends req, authenticated once the nonce is known, and reads the
+// response.
+func (c *turnClient) do(req *message) *message {
+	c.t.Helper()
+	if c.nonce != nil {
+		req.add(attrUsername, []byte("u"))
+		req.add(attrRealm, []byte("test"))
+		req.add(attrNonce, c.nonce)
+	}
+	if _, err := c.conn.Write(req.bytes(c.key)); err != nil {
+		c.t.Fatal(err)
+	}
+	res := c.read()
+	if res.txID != req.txID {
+		c.t.Fatalf("response to %x, want %x", res.txID, req.txID)
+	}
+	return res
+}
+
+func (c *turnClient) read() *message {
+	c.t.Helper()
+	buf := make([]byte, 1500)
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := c.conn.Read(buf)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if buf[0]&0xC0 == 0x40 {
+		return &message{raw: buf[:n]} // ChannelData
+	}
+	m, err := parseMessage(buf[:n])
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return m
+}
+
+func errCode(m *message) int {
+	v, ok := m.get(attrErrorCode)
+	if !ok || len(v) < 4 {
+		return 0
+	}
+	return int(v[2])*100 + int(v[3])
+}
+
+func readPeer(t *testing.T, peer net.PacketConn) (string, net.Addr) {
+	t.Helper()
+	buf := make([]byte, 1500)
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, from, err := peer.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n]), from
+}
+
+func TestRelay(t *testing.T) {
+	loopback := net.ParseIP("127.0.0.1").To4()
+	r := NewRelay("test", loopback, nil)
+	r.Users["u"] = "p"
+	r.BindIP = loopback
+	r.AllowLoopback = true
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	go ServeSTUNPacket(pc, r)
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	peer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	peerAddr := peer.LocalAddr().(*net.UDPAddr)
+	c := &turnClient{t: t, conn: conn}
+	allocate := func() *message {
+		req := newRequest(methodAllocate)
+		req.add(attrRequestedTransport, []byte{protoUDP, 0, 0, 0})
+		return c.do(req)
+	}
+	res := allocate()
+	if errCode(res) != 401 {
+		t.Fatalf("unauthenticated allocate: %d", errCode(res))
+	}
+	c.nonce, _ = res.get(attrNonce)
+	sum := md5.Sum([]byte("u:test:p"))
+	c.key = sum[:]
+	res = allocate()
+	if res.class() != classSuccess || !res.checkIntegrity(c.key) {
+		t.Fatalf("allocate: %d", errCode(res))
+	}
+	v, _ := res.get(attrXORRelayedAddress)
+	ip, port, err := parseXORAddress(v, res.txID)
+	if err != nil || !ip.Equal(loopback) {
+		t.Fatalf("relayed %s:%d, %v", ip, port, err)
+	}
+	relayed := &net.UDPAddr{IP: ip, Port: port}
+
+	permissions := []struct {
+		ip   string
+		code int
+	}{
+		{"10.0.0.1", 403},
+		{"192.168.1.1", 403},
+		{"127.0.0.1", 0},
+	}
+	for _, p := range permissions {
+		req := newRequest(methodCreatePermission)
+		addr := &net.UDPAddr{IP: net.ParseIP(p.ip).To4(), Port: peerAddr.Port}
+		req.add(attrXORPeerAddress, xorAddress(addr, req.txID, true))
+		if res := c.do(req); errCode(res) != p.code {
+			t.Errorf("permission for %s: %d, want %d", p.ip, errCode(res), p.code)
+		}
+	}
+
+	// Send indication to the peer, Data indication back.
+	ind := &message{typ: messageType(methodSend, classIndication), txID: newTxID()}
+	ind.add(attrXORPeerAddress, xorAddress(peerAddr, ind.txID, true))
+	ind.add(attrData, []byte("hello"))
+	if _, err := conn.Write(ind.encode()); err != nil {
+		t.Fatal(err)
+	}
+	if got, from := readPeer(t, peer); got != "hello" || from.String() != relayed.String() {
+		t.Fatalf("peer got %q from %s", got, from)
+	}
+	if _, err := peer.WriteTo([]byte("world"), relayed); err != nil {
+		t.Fatal(err)
+	}
+	data := c.read()
+	if data.method() != methodData || data.class() != classIndication {
+		t.Fatalf("got %#x, want a Data indication", data.typ)
+	}
+	if v, _ := data.get(attrData); string(v) != "world" {
+		t.Errorf("data %q", v)
+	}
+
+	// ChannelData both ways.
+	num := make([]byte, 4)
+	binary.BigEndian.PutUint16(num, 0x4000)
+	bind := newRequest(methodChannelBind)
+	bind.add(attrChannelNumber, num)
+	bind.add(attrXORPeerAddress, xorAddress(peerAddr, bind.txID, true))
+	if res := c.do(bind); res.class() != classSuccess {
+		t.Fatalf("channel bind: %d", errCode(res))
+	}
+	if _, err := conn.Write(append([]byte{0x40, 0x00, 0x00, 0x02}, "ch"...)); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := readPeer(t, peer); got != "ch" {
+		t.Fatalf("peer got %q", got)
+	}
+	if _, err := peer.WriteTo([]byte("back"), relayed); err != nil {
+		t.Fatal(err)
+	}
+	if cd := c.read(); !bytes.Equal(cd.raw, append([]byte{0x40, 0x00, 0x00, 0x04}, "back"...)) {
+		t.Errorf("channel data %x", cd.raw)
+	}
+}